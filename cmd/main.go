@@ -3,9 +3,12 @@ package main
 import (
 	internal "auth-api/internal"
 	"auth-api/internal/config"
+	"auth-api/internal/jobs"
 	"auth-api/internal/kafka"
 	"auth-api/internal/metrics"
+	"auth-api/internal/models"
 	"log"
+	"strings"
 
 	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
@@ -20,6 +23,14 @@ func main() {
 	}
 	config.InitDB()
 
+	// Load (or create) the JWT signing keys
+	if err := models.RotateSigningKeys(); err != nil {
+		log.Fatalf("Unable to initialize signing keys: %v", err)
+	}
+
+	// Start background jobs (key rotation, cleanup)
+	jobs.StartScheduler()
+
 	// Initialize Kafka producer
 	kafka.InitProducer()
 
@@ -29,7 +40,16 @@ func main() {
 	// Register Prometheus metrics
 	metrics.RegisterMetrics()
 
-	app := fiber.New()
+	// Behind a proxy c.IP() would be the proxy's address, which would lump
+	// every client together for login rate limits, sessions and audit events.
+	// The client IP is taken from PROXY_HEADER only on requests coming from
+	// TRUSTED_PROXIES (comma-separated IPs or CIDR ranges).
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             config.GetEnv("PROXY_HEADER", fiber.HeaderXForwardedFor),
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          trustedProxies(),
+		EnableIPValidation:      true,
+	})
 
 	// Add Prometheus middleware to collect HTTP metrics
 	app.Use(metrics.PrometheusMiddleware())
@@ -41,4 +61,16 @@ func main() {
 	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 
 	app.Listen(":3000")
-} 
\ No newline at end of file
+}
+
+// trustedProxies returns the proxies listed in TRUSTED_PROXIES. Without any,
+// proxy headers are ignored.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(config.GetEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
@@ -57,25 +57,29 @@ func initTables() error {
         )
     `
     
-    // Create otp_verifications table
-    otpVerificationsTable := `
-        CREATE TABLE IF NOT EXISTS otp_verifications (
+    // Create one_time_codes table (hashed codes keyed by purpose and subject)
+    oneTimeCodesTable := `
+        CREATE TABLE IF NOT EXISTS one_time_codes (
             id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-            email VARCHAR(255) UNIQUE NOT NULL,
-            otp VARCHAR(10) NOT NULL,
+            purpose VARCHAR(50) NOT NULL,
+            subject VARCHAR(255) NOT NULL,
+            code_hash VARCHAR(64) NOT NULL,
+            data JSONB,
+            attempts INT NOT NULL DEFAULT 0,
             expires_at TIMESTAMP NOT NULL,
-            created_at TIMESTAMP DEFAULT NOW()
+            created_at TIMESTAMP DEFAULT NOW(),
+            UNIQUE (purpose, subject)
         )
     `
     
-    // Create password_reset_otps table
-    passwordResetOTPsTable := `
-        CREATE TABLE IF NOT EXISTS password_reset_otps (
-            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-            email VARCHAR(255) UNIQUE NOT NULL,
-            otp VARCHAR(10) NOT NULL,
-            expires_at TIMESTAMP NOT NULL,
-            created_at TIMESTAMP DEFAULT NOW()
+    // Create one_time_code_sends table (codes sent per subject and day)
+    oneTimeCodeSendsTable := `
+        CREATE TABLE IF NOT EXISTS one_time_code_sends (
+            purpose VARCHAR(50) NOT NULL,
+            subject VARCHAR(255) NOT NULL,
+            day DATE NOT NULL,
+            sent INT NOT NULL DEFAULT 0,
+            PRIMARY KEY (purpose, subject, day)
         )
     `
     
@@ -101,12 +105,250 @@ func initTables() error {
         )
     `
     
+    // Create signing_keys table (JWT key ring, see utils/keys.go)
+    signingKeysTable := `
+        CREATE TABLE IF NOT EXISTS signing_keys (
+            id VARCHAR(64) PRIMARY KEY,
+            algorithm VARCHAR(16) NOT NULL,
+            private_key TEXT NOT NULL,
+            created_at TIMESTAMP DEFAULT NOW(),
+            retired_at TIMESTAMP,
+            verify_until TIMESTAMP
+        )
+    `
+    
+    // Create audit_events table (security and account events)
+    auditEventsTable := `
+        CREATE TABLE IF NOT EXISTS audit_events (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            user_id UUID,
+            event_type VARCHAR(100) NOT NULL,
+            ip_address VARCHAR(64),
+            user_agent TEXT,
+            details JSONB,
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    // Create oauth_clients table (services using introspection/revocation)
+    oauthClientsTable := `
+        CREATE TABLE IF NOT EXISTS oauth_clients (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            client_id VARCHAR(100) UNIQUE NOT NULL,
+            client_secret_hash VARCHAR(255) NOT NULL,
+            name VARCHAR(255) NOT NULL,
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    // Create user_totp table (TOTP second factor)
+    userTOTPTable := `
+        CREATE TABLE IF NOT EXISTS user_totp (
+            user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+            secret VARCHAR(64) NOT NULL,
+            enabled BOOLEAN DEFAULT FALSE,
+            last_used_step BIGINT DEFAULT 0,
+            created_at TIMESTAMP DEFAULT NOW(),
+            confirmed_at TIMESTAMP
+        )
+    `
+    
+    // Create mfa_recovery_codes table (single-use, hashed)
+    mfaRecoveryCodesTable := `
+        CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            code_hash VARCHAR(255) NOT NULL,
+            used_at TIMESTAMP,
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    // Create mfa_challenges table (pending second-factor logins)
+    mfaChallengesTable := `
+        CREATE TABLE IF NOT EXISTS mfa_challenges (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            token_hash VARCHAR(255) UNIQUE NOT NULL,
+            device_name VARCHAR(255),
+            attempts INT DEFAULT 0,
+            expires_at TIMESTAMP NOT NULL,
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    // Create webauthn_credentials table (passkeys and security keys)
+    webauthnCredentialsTable := `
+        CREATE TABLE IF NOT EXISTS webauthn_credentials (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            credential_id BYTEA UNIQUE NOT NULL,
+            name VARCHAR(255) NOT NULL,
+            data JSONB NOT NULL,
+            created_at TIMESTAMP DEFAULT NOW(),
+            last_used_at TIMESTAMP
+        )
+    `
+    
+    // Create webauthn_sessions table (in-flight registration/login ceremonies)
+    webauthnSessionsTable := `
+        CREATE TABLE IF NOT EXISTS webauthn_sessions (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+            ceremony VARCHAR(20) NOT NULL,
+            data JSONB NOT NULL,
+            expires_at TIMESTAMP NOT NULL,
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    // Create login_ip_attempts table (failed logins per client IP)
+    loginIPAttemptsTable := `
+        CREATE TABLE IF NOT EXISTS login_ip_attempts (
+            ip_address VARCHAR(64) PRIMARY KEY,
+            failed_attempts INT DEFAULT 0,
+            window_started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+            last_failed_at TIMESTAMP,
+            blocked_until TIMESTAMP
+        )
+    `
+    
+    // Create data_exports table (personal data export jobs and their archives)
+    dataExportsTable := `
+        CREATE TABLE IF NOT EXISTS data_exports (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            format VARCHAR(10) NOT NULL,
+            status VARCHAR(20) NOT NULL DEFAULT 'pending',
+            token_hash VARCHAR(64),
+            archive BYTEA,
+            error TEXT,
+            created_at TIMESTAMP DEFAULT NOW(),
+            started_at TIMESTAMP,
+            completed_at TIMESTAMP,
+            expires_at TIMESTAMP
+        )
+    `
+    
+    // Create password_history table (previous password hashes, to stop reuse)
+    passwordHistoryTable := `
+        CREATE TABLE IF NOT EXISTS password_history (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            password_hash VARCHAR(255) NOT NULL,
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    // Create roles, permissions and their grants (role-based access control)
+    rolesTable := `
+        CREATE TABLE IF NOT EXISTS roles (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            name VARCHAR(50) UNIQUE NOT NULL,
+            description TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    permissionsTable := `
+        CREATE TABLE IF NOT EXISTS permissions (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            name VARCHAR(100) UNIQUE NOT NULL,
+            description TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    rolePermissionsTable := `
+        CREATE TABLE IF NOT EXISTS role_permissions (
+            role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+            permission_id UUID NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+            PRIMARY KEY (role_id, permission_id)
+        )
+    `
+    
+    userRolesTable := `
+        CREATE TABLE IF NOT EXISTS user_roles (
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+            created_at TIMESTAMP DEFAULT NOW(),
+            PRIMARY KEY (user_id, role_id)
+        )
+    `
+    
+    // Create organizations (tenants), their members and pending invitations
+    organizationsTable := `
+        CREATE TABLE IF NOT EXISTS organizations (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            name VARCHAR(255) NOT NULL,
+            slug VARCHAR(100) UNIQUE NOT NULL,
+            created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    organizationMembersTable := `
+        CREATE TABLE IF NOT EXISTS organization_members (
+            organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            role VARCHAR(20) NOT NULL DEFAULT 'member',
+            created_at TIMESTAMP DEFAULT NOW(),
+            PRIMARY KEY (organization_id, user_id)
+        )
+    `
+    
+    organizationInvitationsTable := `
+        CREATE TABLE IF NOT EXISTS organization_invitations (
+            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+            organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+            email VARCHAR(255) NOT NULL,
+            role VARCHAR(20) NOT NULL DEFAULT 'member',
+            token_hash VARCHAR(255) UNIQUE NOT NULL,
+            invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+            expires_at TIMESTAMP NOT NULL,
+            created_at TIMESTAMP DEFAULT NOW()
+        )
+    `
+    
+    // Create event_outbox table (events written in the same transaction as
+    // the change they announce, published to Kafka by a background job)
+    eventOutboxTable := `
+        CREATE TABLE IF NOT EXISTS event_outbox (
+            id BIGSERIAL PRIMARY KEY,
+            event_type VARCHAR(100) NOT NULL,
+            user_id UUID,
+            data JSONB,
+            occurred_at TIMESTAMP NOT NULL DEFAULT NOW(),
+            attempts INT NOT NULL DEFAULT 0,
+            last_error TEXT
+        )
+    `
+    
     tables := []string{
         usersTable,
-        otpVerificationsTable,
-        passwordResetOTPsTable,
+        oneTimeCodesTable,
+        oneTimeCodeSendsTable,
         refreshTokensTable,
         accessTokensTable,
+        signingKeysTable,
+        auditEventsTable,
+        oauthClientsTable,
+        userTOTPTable,
+        mfaRecoveryCodesTable,
+        mfaChallengesTable,
+        webauthnCredentialsTable,
+        webauthnSessionsTable,
+        loginIPAttemptsTable,
+        dataExportsTable,
+        passwordHistoryTable,
+        rolesTable,
+        permissionsTable,
+        rolePermissionsTable,
+        userRolesTable,
+        organizationsTable,
+        organizationMembersTable,
+        organizationInvitationsTable,
+        eventOutboxTable,
     }
     
     for _, table := range tables {
@@ -115,6 +357,143 @@ func initTables() error {
         }
     }
     
+    // Columns added after the tables were first created
+    migrations := []string{
+        // Refresh token families: every login starts a family, every
+        // rotation adds a child pointing at its parent
+        `ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id UUID NOT NULL DEFAULT gen_random_uuid()`,
+        `ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS parent_id UUID`,
+        `ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP`,
+        `ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP`,
+        `CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id)`,
+        // Session (device) metadata recorded at login
+        `ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent TEXT`,
+        `ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip_address VARCHAR(64)`,
+        `ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS device_name VARCHAR(255)`,
+        `ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP`,
+        `CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id)`,
+        `ALTER TABLE access_tokens ADD COLUMN IF NOT EXISTS session_id UUID`,
+        `CREATE INDEX IF NOT EXISTS access_tokens_session_id_idx ON access_tokens (session_id)`,
+        `CREATE INDEX IF NOT EXISTS audit_events_user_id_idx ON audit_events (user_id, created_at)`,
+        // Failed login tracking for backoff and account lockout
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_login_attempts INT NOT NULL DEFAULT 0`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS last_failed_login_at TIMESTAMP`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP`,
+        // Profile fields
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(100) NOT NULL DEFAULT ''`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS given_name VARCHAR(100) NOT NULL DEFAULT ''`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS family_name VARCHAR(100) NOT NULL DEFAULT ''`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url VARCHAR(2048) NOT NULL DEFAULT ''`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(35) NOT NULL DEFAULT ''`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT ''`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW()`,
+        // Self-service account deletion: soft-deleted accounts are purged
+        // once the grace period is over
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS purge_after TIMESTAMP`,
+        `CREATE INDEX IF NOT EXISTS users_purge_after_idx ON users (purge_after) WHERE purge_after IS NOT NULL`,
+        `CREATE INDEX IF NOT EXISTS password_history_user_id_idx ON password_history (user_id, created_at)`,
+        // Built-in roles and permissions. Admins get the built-in permissions;
+        // users.role is kept as the primary role, and accounts from before
+        // user_roles existed get it as their only role.
+        `INSERT INTO roles (name, description) VALUES
+            ('user', 'Every account'),
+            ('admin', 'Administrators')
+         ON CONFLICT (name) DO NOTHING`,
+        `INSERT INTO permissions (name, description) VALUES
+            ('users:read', 'View user accounts'),
+            ('users:write', 'Unlock and manage user accounts'),
+            ('sessions:read', 'View the sessions of any user'),
+            ('sessions:write', 'Revoke the sessions of any user'),
+            ('oauth_clients:read', 'View OAuth clients'),
+            ('oauth_clients:write', 'Create and delete OAuth clients'),
+            ('roles:read', 'View roles, permissions and role assignments'),
+            ('roles:write', 'Manage roles, permissions and role assignments')
+         ON CONFLICT (name) DO NOTHING`,
+        `INSERT INTO role_permissions (role_id, permission_id)
+         SELECT r.id, p.id FROM roles r, permissions p
+         WHERE r.name = 'admin' AND p.name IN ('users:read', 'users:write', 'sessions:read', 'sessions:write',
+                                               'oauth_clients:read', 'oauth_clients:write', 'roles:read', 'roles:write')
+           AND NOT EXISTS (SELECT 1 FROM role_permissions rp WHERE rp.role_id = r.id)`,
+        `INSERT INTO user_roles (user_id, role_id)
+         SELECT u.id, r.id FROM users u JOIN roles r ON r.name = u.role
+         WHERE NOT EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = u.id)`,
+        `CREATE INDEX IF NOT EXISTS user_roles_role_id_idx ON user_roles (role_id)`,
+        // Roles of the default ROLE_HIERARCHY; their permissions come from
+        // ROLE_PERMISSIONS and from the roles below them
+        `INSERT INTO roles (name, description) VALUES
+            ('support', 'Support staff'),
+            ('super_admin', 'Administrators of administrators')
+         ON CONFLICT (name) DO NOTHING`,
+        // Organizations: the member index serves "my organizations", and
+        // every session remembers the organization it is working in
+        `CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON organization_members (user_id)`,
+        `CREATE INDEX IF NOT EXISTS organization_invitations_organization_id_idx ON organization_invitations (organization_id)`,
+        `ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS organization_id UUID REFERENCES organizations(id) ON DELETE SET NULL`,
+        // Admin user management: forced password resets, plus indexes for
+        // searching and paging through users
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE`,
+        `CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at, id)`,
+        `CREATE INDEX IF NOT EXISTS users_email_lower_idx ON users (LOWER(email) text_pattern_ops)`,
+        // Account status (active, suspended, locked, pending_deletion) with a
+        // reason and an optional expiry. Accounts disabled before statuses
+        // existed become suspended, and current locks and pending deletions
+        // are carried over.
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT ''`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS status_expires_at TIMESTAMP`,
+        `ALTER TABLE users ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP`,
+        `DO $$
+        BEGIN
+            IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'disabled_at') THEN
+                UPDATE users SET status = 'suspended', status_changed_at = disabled_at
+                WHERE disabled_at IS NOT NULL AND status = 'active';
+                ALTER TABLE users DROP COLUMN disabled_at;
+            END IF;
+        END $$`,
+        `UPDATE users SET status = 'pending_deletion', status_reason = 'Deletion requested',
+                status_expires_at = purge_after, status_changed_at = deleted_at
+         WHERE deleted_at IS NOT NULL AND status = 'active'`,
+        `UPDATE users SET status = 'locked', status_reason = 'Too many failed login attempts',
+                status_expires_at = locked_until, status_changed_at = NOW()
+         WHERE locked_until > NOW() AND status = 'active'`,
+        `CREATE INDEX IF NOT EXISTS users_status_idx ON users (status) WHERE status <> 'active'`,
+        // The per-flow OTP tables were folded into one_time_codes. Codes
+        // still stored in plaintext are hashed on the way over.
+        `DO $$
+        DECLARE
+            legacy RECORD;
+        BEGIN
+            FOR legacy IN
+                SELECT * FROM (VALUES ('otp_verifications', 'verify_email'), ('password_reset_otps', 'reset_password')) AS t(tbl, purpose)
+            LOOP
+                IF to_regclass(legacy.tbl) IS NOT NULL THEN
+                    EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS otp_hash VARCHAR(64)', legacy.tbl);
+                    EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0', legacy.tbl);
+                    EXECUTE format(
+                        'INSERT INTO one_time_codes (purpose, subject, code_hash, attempts, expires_at, created_at)
+                         SELECT %L, email,
+                                COALESCE(otp_hash, encode(sha256(convert_to(email || '':'' || upper(otp), ''UTF8'')), ''hex'')),
+                                attempts, expires_at, created_at
+                         FROM %I WHERE otp_hash IS NOT NULL OR otp IS NOT NULL
+                         ON CONFLICT (purpose, subject) DO NOTHING',
+                        legacy.purpose, legacy.tbl);
+                    EXECUTE format('DROP TABLE %I', legacy.tbl);
+                END IF;
+            END LOOP;
+        END $$`,
+        // Signing keys are published ahead of signing with them, so every
+        // instance and JWKS consumer knows a key before tokens carry its kid
+        `ALTER TABLE signing_keys ADD COLUMN IF NOT EXISTS activates_at TIMESTAMP`,
+        `UPDATE signing_keys SET activates_at = created_at WHERE activates_at IS NULL`,
+    }
+    
+    for _, migration := range migrations {
+        if _, err := DB.Exec(ctx, migration); err != nil {
+            return fmt.Errorf("failed to apply migration: %v", err)
+        }
+    }
+    
     log.Println("📦 Database tables initialized successfully")
     return nil
 }
@@ -0,0 +1,103 @@
+package config
+
+import "strings"
+
+const (
+	defaultRoleHierarchy   = "super_admin>admin>support>user"
+	defaultRolePermissions = "super_admin=*;admin=users:*,sessions:*,oauth_clients:*,roles:*;support=users:read,sessions:read"
+)
+
+// ImpliedRoles returns a role together with every role below it in
+// ROLE_HIERARCHY, so that a higher role passes any lower-role check.
+//
+// ROLE_HIERARCHY is a comma separated list of chains from the highest role
+// down, e.g. "super_admin>admin>support>user,admin>auditor". Chains may share
+// roles, which lets a role sit below several others.
+func ImpliedRoles(role string) []string {
+	below := roleEdges()
+
+	roles := []string{role}
+	seen := map[string]bool{role: true}
+	for i := 0; i < len(roles); i++ {
+		for _, lower := range below[roles[i]] {
+			if !seen[lower] {
+				seen[lower] = true
+				roles = append(roles, lower)
+			}
+		}
+	}
+	return roles
+}
+
+// RolePermissions returns the permission patterns ROLE_PERMISSIONS grants a
+// role directly, on top of those granted in the database. Patterns may end in
+// a wildcard segment: "users:*" grants every users permission and "*" grants
+// everything.
+//
+// ROLE_PERMISSIONS is a semicolon separated list of role=pattern,pattern
+// entries, e.g. "support=users:read,sessions:read;auditor=*:read".
+func RolePermissions(role string) []string {
+	var patterns []string
+	for _, entry := range strings.Split(GetEnv("ROLE_PERMISSIONS", defaultRolePermissions), ";") {
+		name, list, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) != role {
+			continue
+		}
+		for _, pattern := range strings.Split(list, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns
+}
+
+// RolesAbove returns a role together with every role above it in
+// ROLE_HIERARCHY, that is every role that implies it
+func RolesAbove(role string) []string {
+	above := map[string][]string{}
+	for higher, lowers := range roleEdges() {
+		for _, lower := range lowers {
+			above[lower] = append(above[lower], higher)
+		}
+	}
+
+	roles := []string{role}
+	seen := map[string]bool{role: true}
+	for i := 0; i < len(roles); i++ {
+		for _, higher := range above[roles[i]] {
+			if !seen[higher] {
+				seen[higher] = true
+				roles = append(roles, higher)
+			}
+		}
+	}
+	return roles
+}
+
+// IsHierarchyRole reports whether ROLE_HIERARCHY names a role
+func IsHierarchyRole(role string) bool {
+	for _, chain := range strings.Split(GetEnv("ROLE_HIERARCHY", defaultRoleHierarchy), ",") {
+		for _, name := range strings.Split(chain, ">") {
+			if strings.TrimSpace(name) == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// roleEdges maps each role in ROLE_HIERARCHY to the roles directly below it
+func roleEdges() map[string][]string {
+	below := map[string][]string{}
+	for _, chain := range strings.Split(GetEnv("ROLE_HIERARCHY", defaultRoleHierarchy), ",") {
+		roles := strings.Split(chain, ">")
+		for i := 0; i+1 < len(roles); i++ {
+			higher, lower := strings.TrimSpace(roles[i]), strings.TrimSpace(roles[i+1])
+			if higher != "" && lower != "" {
+				below[higher] = append(below[higher], lower)
+			}
+		}
+	}
+	return below
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// multiChainHierarchy has auditor below both admin and support, and billing
+// in a chain of its own under super_admin
+const multiChainHierarchy = "super_admin>admin>support>user,admin>auditor,support>auditor,super_admin>billing,billing>user"
+
+func sorted(roles []string) []string {
+	roles = append([]string(nil), roles...)
+	sort.Strings(roles)
+	return roles
+}
+
+func TestImpliedRoles(t *testing.T) {
+	tests := []struct {
+		hierarchy string
+		role      string
+		want      []string
+	}{
+		{"", "super_admin", []string{"admin", "super_admin", "support", "user"}},
+		{"", "admin", []string{"admin", "support", "user"}},
+		{"", "user", []string{"user"}},
+		{"", "custom", []string{"custom"}},
+		{multiChainHierarchy, "super_admin", []string{"admin", "auditor", "billing", "super_admin", "support", "user"}},
+		{multiChainHierarchy, "admin", []string{"admin", "auditor", "support", "user"}},
+		{multiChainHierarchy, "support", []string{"auditor", "support", "user"}},
+		{multiChainHierarchy, "billing", []string{"billing", "user"}},
+		{multiChainHierarchy, "auditor", []string{"auditor"}},
+		{" admin > support ", "admin", []string{"admin", "support"}},
+		// A cycle must not loop forever
+		{"a>b,b>a", "a", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hierarchy+" "+tt.role, func(t *testing.T) {
+			t.Setenv("ROLE_HIERARCHY", tt.hierarchy)
+			if got := sorted(ImpliedRoles(tt.role)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ImpliedRoles(%q) = %v, want %v", tt.role, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRolesAbove(t *testing.T) {
+	tests := []struct {
+		hierarchy string
+		role      string
+		want      []string
+	}{
+		{"", "user", []string{"admin", "super_admin", "support", "user"}},
+		{"", "admin", []string{"admin", "super_admin"}},
+		{"", "super_admin", []string{"super_admin"}},
+		{"", "custom", []string{"custom"}},
+		{multiChainHierarchy, "auditor", []string{"admin", "auditor", "super_admin", "support"}},
+		{multiChainHierarchy, "user", []string{"admin", "billing", "super_admin", "support", "user"}},
+		{multiChainHierarchy, "billing", []string{"billing", "super_admin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hierarchy+" "+tt.role, func(t *testing.T) {
+			t.Setenv("ROLE_HIERARCHY", tt.hierarchy)
+			if got := sorted(RolesAbove(tt.role)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RolesAbove(%q) = %v, want %v", tt.role, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsHierarchyRole(t *testing.T) {
+	t.Setenv("ROLE_HIERARCHY", multiChainHierarchy+",solo")
+	for role, want := range map[string]bool{
+		"super_admin": true,
+		"auditor":     true,
+		"billing":     true,
+		"solo":        true,
+		"custom":      false,
+		"":            false,
+	} {
+		if got := IsHierarchyRole(role); got != want {
+			t.Errorf("IsHierarchyRole(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		config string
+		role   string
+		want   []string
+	}{
+		{"", "super_admin", []string{"*"}},
+		{"", "admin", []string{"users:*", "sessions:*", "oauth_clients:*", "roles:*"}},
+		{"", "support", []string{"users:read", "sessions:read"}},
+		{"", "user", nil},
+		{"auditor=*:read", "auditor", []string{"*:read"}},
+		{" auditor = users:read , ,sessions:read ;support=x", "auditor", []string{"users:read", "sessions:read"}},
+		// Entries for the same role add up
+		{"auditor=users:read;auditor=sessions:read", "auditor", []string{"users:read", "sessions:read"}},
+		{"auditor", "auditor", nil},
+		{"auditors=users:read", "auditor", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.config+" "+tt.role, func(t *testing.T) {
+			t.Setenv("ROLE_PERMISSIONS", tt.config)
+			if got := RolePermissions(tt.role); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RolePermissions(%q) = %v, want %v", tt.role, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/kafka"
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type RestoreAccountRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// DeleteAccount soft-deletes the current user's account after checking the
+// password. Every session ends right away; the account can be restored
+// until ACCOUNT_DELETION_GRACE_PERIOD is over and is purged after that.
+func DeleteAccount(c *fiber.Ctx) error {
+	var req DeleteAccountRequest
+
+	if err := c.BodyParser(&req); err != nil || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Password is required",
+		})
+	}
+
+	user, err := models.GetUserByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if ok, err := verifyCurrentPassword(c, user, req.Password); !ok {
+		return err
+	}
+
+	purgeAfter := time.Now().Add(config.GetEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour))
+	if err := models.SoftDeleteUser(user.ID, purgeAfter); err != nil {
+		log.Printf("Failed to delete account: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete account",
+		})
+	}
+
+	if err := models.DeleteAllRefreshTokensForUser(user.ID); err != nil {
+		log.Printf("Failed to delete refresh tokens: %v", err)
+	}
+	if err := models.RevokeAllAccessTokensForUser(user.ID); err != nil {
+		log.Printf("Failed to revoke access tokens: %v", err)
+	}
+
+	recordSecurityEvent(c, user.ID, "account.deletion_scheduled", fiber.Map{
+		"purge_after": purgeAfter,
+	})
+
+	if err := kafka.SendAccountDeletionScheduledEmail(user.Email, purgeAfter); err != nil {
+		log.Printf("Failed to send account deletion email: %v", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Account scheduled for deletion",
+		"purge_after": purgeAfter,
+	})
+}
+
+// RestoreAccount cancels a pending deletion. The user logs in normally
+// afterwards.
+func RestoreAccount(c *fiber.Ctx) error {
+	var req RestoreAccountRequest
+
+	if err := c.BodyParser(&req); err != nil || req.Email == "" || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email and password are required",
+		})
+	}
+
+	user, err := models.GetUserByEmail(req.Email)
+	if err != nil {
+		recordLoginFailure(c, nil)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid email or password",
+		})
+	}
+
+	retryAfter, _, err := claimLoginAttempt(c, user)
+	if err != nil {
+		log.Printf("Failed to check login throttling: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to restore account",
+		})
+	}
+	if retryAfter > 0 {
+		return tooManyLoginAttempts(c, retryAfter, "Too many failed login attempts, try again later")
+	}
+
+	if !utils.CheckPasswordHash(req.Password, user.PasswordHash) {
+		recordClaimedLoginFailure(c, user)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid email or password",
+		})
+	}
+	if err := models.ResetUserLoginFailures(user.ID); err != nil {
+		log.Printf("Failed to reset failed login counter: %v", err)
+	}
+
+	restored, err := models.RestoreUser(user.ID)
+	if err != nil {
+		log.Printf("Failed to restore account: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to restore account",
+		})
+	}
+	if !restored {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Account is not scheduled for deletion",
+		})
+	}
+
+	recordSecurityEvent(c, user.ID, "account.restored", nil)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Account restored. You can log in again.",
+	})
+}
+
+// accountActive rejects logins and token refreshes for accounts that are
+// suspended, scheduled for deletion or waiting for a forced password reset.
+// Locked accounts are only refused on the password path, see
+// loginRetryAfter. When the account is not active the response is already
+// written and ok is false.
+func accountActive(c *fiber.Ctx, user *models.User) (bool, error) {
+	if user.Status == models.StatusSuspended {
+		return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":           "Account is suspended",
+			"reason":          user.StatusReason,
+			"suspended_until": user.StatusExpiresAt,
+		})
+	}
+	if user.DeletedAt != nil {
+		return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":       "Account is scheduled for deletion",
+			"purge_after": user.PurgeAfter,
+		})
+	}
+	if user.PasswordResetRequired {
+		return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Password reset required",
+		})
+	}
+	return true, nil
+}
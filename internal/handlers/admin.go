@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"auth-api/internal/kafka"
+	"auth-api/internal/metrics"
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SetUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason"`
+	// ExpiresAt ends the suspension by itself; without it the suspension
+	// lasts until the user is reactivated
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ListUsers searches users for admins. Query parameters: email (prefix),
+// role, status, verified (true/false), created_after and created_before (RFC 3339),
+// sort (created_at or email), order (asc or desc), cursor and limit
+// (default 50, at most 200).
+func ListUsers(c *fiber.Ctx) error {
+	search := models.UserSearch{
+		EmailPrefix: c.Query("email"),
+		Role:        c.Query("role"),
+		Status:      c.Query("status"),
+		Sort:        c.Query("sort", "created_at"),
+		Descending:  c.Query("order") == "desc",
+		Cursor:      c.Query("cursor"),
+		Limit:       c.QueryInt("limit", 50),
+	}
+
+	if search.Sort != "created_at" && search.Sort != "email" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "sort must be created_at or email",
+		})
+	}
+	if order := c.Query("order"); order != "" && order != "asc" && order != "desc" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "order must be asc or desc",
+		})
+	}
+	if search.Limit < 1 || search.Limit > 200 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "limit must be between 1 and 200",
+		})
+	}
+	switch search.Status {
+	case "", models.StatusActive, models.StatusSuspended, models.StatusLocked, models.StatusPendingDeletion:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "status must be active, suspended, locked or pending_deletion",
+		})
+	}
+	if verified := c.Query("verified"); verified != "" {
+		value, err := strconv.ParseBool(verified)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "verified must be true or false",
+			})
+		}
+		search.Verified = &value
+	}
+	for param, target := range map[string]**time.Time{
+		"created_after":  &search.CreatedAfter,
+		"created_before": &search.CreatedBefore,
+	} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": param + " must be an RFC 3339 timestamp",
+				})
+			}
+			t = t.UTC()
+			*target = &t
+		}
+	}
+
+	page, err := models.SearchUsers(search)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to search users: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list users",
+		})
+	}
+
+	// Reading user data is audited like changing it
+	recordSecurityEvent(c, "", "admin.users_searched", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+		"search": fiber.Map{
+			"email":          search.EmailPrefix,
+			"role":           search.Role,
+			"status":         search.Status,
+			"verified":       search.Verified,
+			"created_after":  search.CreatedAfter,
+			"created_before": search.CreatedBefore,
+			"cursor":         search.Cursor,
+		},
+		"results": len(page.Users),
+	})
+
+	return c.Status(fiber.StatusOK).JSON(page)
+}
+
+// AdminGetUser returns one user with their roles and active sessions
+func AdminGetUser(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	roles, err := models.GetUserRoles(user.ID)
+	if err != nil {
+		log.Printf("Failed to load user roles: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load user",
+		})
+	}
+	sessions, err := models.ListSessionsForUser(user.ID)
+	if err != nil {
+		log.Printf("Failed to load user sessions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load user",
+		})
+	}
+
+	recordSecurityEvent(c, user.ID, "admin.user_viewed", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"user":     user,
+		"roles":    roles,
+		"sessions": sessions,
+	})
+}
+
+// SetUserRole changes a user's primary role. Admins can only hand out roles
+// they have themselves.
+func SetUserRole(c *fiber.Ctx) error {
+	var req SetUserRoleRequest
+	if err := c.BodyParser(&req); err != nil || req.Role == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role is required",
+		})
+	}
+
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if !middleware.HasRole(c, req.Role) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot assign a role above your own",
+		})
+	}
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+
+	found, err := models.SetUserRole(user.ID, req.Role)
+	if err != nil {
+		log.Printf("Failed to set user role: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to change role",
+		})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+
+	// Their access tokens carry the old primary role
+	revokeAccessTokensOf([]string{user.ID})
+	recordSecurityEvent(c, user.ID, "account.role_changed", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+		"old_role": user.Role,
+		"new_role": req.Role,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Role changed",
+		"user_id": user.ID,
+		"role":    req.Role,
+	})
+}
+
+// AdminVerifyUser marks a user's email as verified without a code
+func AdminVerifyUser(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+
+	if !user.IsVerified {
+		if err := models.MarkUserVerified(user.ID); err != nil {
+			log.Printf("Failed to verify user: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to verify user",
+			})
+		}
+		recordSecurityEvent(c, user.ID, "account.verified_by_admin", fiber.Map{
+			"admin_id": middleware.GetUserID(c),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User verified",
+		"user_id": user.ID,
+	})
+}
+
+// ForcePasswordReset signs a user out everywhere and blocks sign-in until
+// they reset their password. A reset code is emailed to them.
+func ForcePasswordReset(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+
+	if err := models.RequirePasswordReset(user.ID); err != nil {
+		log.Printf("Failed to require password reset: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to force password reset",
+		})
+	}
+	if err := revokeAllTokens(user.ID); err != nil {
+		log.Printf("Failed to revoke tokens: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to force password reset",
+		})
+	}
+
+	recordSecurityEvent(c, user.ID, "password.reset_forced", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+	})
+
+	// The reset itself goes through the usual reset-password flow. A code
+	// issued moments ago is still valid, so rate limiting is not an error.
+	emailSent := false
+	otp, err := models.IssueCode(models.PurposeResetPassword, user.Email, nil)
+	switch {
+	case errors.Is(err, models.ErrCodeRecentlyIssued), errors.Is(err, models.ErrCodeDailyLimit):
+	case err != nil:
+		log.Printf("Failed to issue password reset code: %v", err)
+	default:
+		if err := kafka.SendPasswordResetOTPEmail(user.Email, otp); err != nil {
+			metrics.RecordEmailSent("password_reset", false)
+			log.Printf("Failed to send password reset code: %v", err)
+		} else {
+			metrics.RecordEmailSent("password_reset", true)
+			emailSent = true
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Password reset required",
+		"user_id":    user.ID,
+		"email_sent": emailSent,
+	})
+}
+
+// SuspendUser blocks a user from signing in, indefinitely or until
+// expires_at, and ends all of their sessions right away
+func SuspendUser(c *fiber.Ctx) error {
+	var req SuspendUserRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > 500 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Reason is required and must be at most 500 characters",
+		})
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expires_at must be in the future",
+		})
+	}
+
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if user.ID == middleware.GetUserID(c) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot suspend your own account",
+		})
+	}
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+
+	if req.ExpiresAt != nil {
+		expiresAt := req.ExpiresAt.UTC()
+		req.ExpiresAt = &expiresAt
+	}
+	err = models.SuspendUser(user.ID, req.Reason, req.ExpiresAt)
+	if err == nil {
+		err = revokeAllTokens(user.ID)
+	}
+	if err != nil {
+		log.Printf("Failed to suspend user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to suspend user",
+		})
+	}
+
+	recordSecurityEvent(c, user.ID, "account.suspended", fiber.Map{
+		"admin_id":   middleware.GetUserID(c),
+		"reason":     req.Reason,
+		"expires_at": req.ExpiresAt,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "User suspended",
+		"user_id":    user.ID,
+		"expires_at": req.ExpiresAt,
+	})
+}
+
+// ReactivateUser lifts a user's suspension
+func ReactivateUser(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+
+	reactivated, err := models.ReactivateUser(user.ID)
+	if err != nil {
+		log.Printf("Failed to reactivate user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reactivate user",
+		})
+	}
+	if !reactivated {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "User is not suspended",
+		})
+	}
+
+	recordSecurityEvent(c, user.ID, "account.reactivated", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User reactivated",
+		"user_id": user.ID,
+	})
+}
+
+// RevokeUserSessions logs a user out everywhere by revoking all of their
+// refresh and access tokens
+func RevokeUserSessions(c *fiber.Ctx) error {
+	userID := c.Params("id")
+
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+
+	if err := revokeAllTokens(user.ID); err != nil {
+		log.Printf("Failed to revoke tokens: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke sessions",
+		})
+	}
+
+	recordSecurityEvent(c, user.ID, "session.revoked_all", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "All sessions revoked",
+		"user_id": user.ID,
+	})
+}
+
+// manageableUser checks that the caller has every role of the user they are
+// about to act on, so admins cannot act on accounts above their own. When
+// they do not, the response is already written and ok is false.
+func manageableUser(c *fiber.Ctx, user *models.User) (bool, error) {
+	roles, err := models.GetUserRoles(user.ID)
+	if err != nil {
+		log.Printf("Failed to load user roles: %v", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load user",
+		})
+	}
+
+	for _, role := range roles {
+		if !middleware.HasRole(c, role) {
+			return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Cannot manage a user with a role above your own",
+			})
+		}
+	}
+	return true, nil
+}
+
+// revokeAllTokens ends every session of a user by deleting their refresh
+// tokens and revoking their access tokens
+func revokeAllTokens(userID string) error {
+	if err := models.DeleteAllRefreshTokensForUser(userID); err != nil {
+		return err
+	}
+	return models.RevokeAllAccessTokensForUser(userID)
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"auth-api/internal/config"
 	"auth-api/internal/kafka"
 	"auth-api/internal/metrics"
+	"auth-api/internal/middleware"
 	"auth-api/internal/models"
 	"auth-api/internal/utils"
+	"errors"
 	"log"
 	"time"
 
@@ -22,8 +25,9 @@ type VerifyUserRequest struct {
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	DeviceName string `json:"device_name"`
 }
 
 func SignUp(c *fiber.Ctx) error {
@@ -36,6 +40,18 @@ func SignUp(c *fiber.Ctx) error {
 		})
 	}
 
+	if req.Email == "" {
+		metrics.RecordAuthSignup(false)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email is required",
+		})
+	}
+
+	if ok, err := checkNewPassword(c, req.Password, req.Email); !ok {
+		metrics.RecordAuthSignup(false)
+		return err
+	}
+
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
 		metrics.RecordAuthSignup(false)
@@ -52,8 +68,7 @@ func SignUp(c *fiber.Ctx) error {
 		})
 	}
 
-	otp := utils.GenerateOTP()
-	err = models.StoreOTP(req.Email, otp)
+	otp, err := models.IssueCode(models.PurposeVerifyEmail, req.Email, nil)
 	if err != nil {
 		metrics.RecordAuthSignup(false)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -94,9 +109,7 @@ func VerifyUser(c *fiber.Ctx) error {
 
 	err := models.VerifyUserOTP(req.Email, req.OTP)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return otpError(c, err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -104,13 +117,70 @@ func VerifyUser(c *fiber.Ctx) error {
 	})
 }
 
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// ResendVerification sends a new email verification code. The response is the
+// same whether the email is unknown, already verified or throttled, so it
+// cannot be used to find out which addresses have accounts.
+func ResendVerification(c *fiber.Ctx) error {
+	var req ResendVerificationRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	if req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email is required",
+		})
+	}
+
+	response := fiber.Map{
+		"message": "If the email belongs to an unverified account, a new verification code has been sent",
+	}
+
+	user, err := models.GetUserByEmail(req.Email)
+	if err != nil || user.IsVerified {
+		metrics.RecordVerificationResend("skipped")
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+
+	otp, err := models.IssueCode(models.PurposeVerifyEmail, user.Email, nil)
+	switch {
+	case errors.Is(err, models.ErrCodeRecentlyIssued):
+		metrics.RecordVerificationResend("cooldown")
+		return c.Status(fiber.StatusOK).JSON(response)
+	case errors.Is(err, models.ErrCodeDailyLimit):
+		metrics.RecordVerificationResend("daily_limit")
+		return c.Status(fiber.StatusOK).JSON(response)
+	case err != nil:
+		log.Printf("Failed to issue verification code: %v", err)
+		metrics.RecordVerificationResend("error")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store OTP",
+		})
+	}
+
+	if err := kafka.SendOTPEmail(user.Email, otp); err != nil {
+		metrics.RecordVerificationResend("error")
+		metrics.RecordEmailSent("verification", false)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send OTP email",
+		})
+	}
+
+	metrics.RecordVerificationResend("sent")
+	metrics.RecordEmailSent("verification", true)
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
 func Login(c *fiber.Ctx) error {
 	var req LoginRequest
 
-	// Debug: Log the raw body
-	body := c.Body()
-	log.Printf("Raw request body: %s", string(body))
-
 	if err := c.BodyParser(&req); err != nil {
 		log.Printf("BodyParser error: %v", err)
 		metrics.RecordAuthLogin(false)
@@ -119,9 +189,6 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Debug: Log the parsed request
-	log.Printf("Parsed request: %+v", req)
-
 	// Validate required fields
 	if req.Email == "" || req.Password == "" {
 		metrics.RecordAuthLogin(false)
@@ -132,77 +199,83 @@ func Login(c *fiber.Ctx) error {
 
 	// Check if user exists and password is correct
 	user, err := models.GetUserByEmail(req.Email)
-	if err != nil {
+	if err != nil && !models.IsNotFound(err) {
 		metrics.RecordAuthLogin(false)
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid email or password",
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to look up user",
 		})
 	}
+	if err != nil {
+		user = nil
+	}
 
-	// Verify password
-	if !utils.CheckPasswordHash(req.Password, user.PasswordHash) {
+	// Refuse blocked IPs and locked or backed-off accounts, and count the
+	// attempt, before checking the password
+	retryAfter, scope, err := claimLoginAttempt(c, user)
+	if err != nil {
+		log.Printf("Failed to check login throttling: %v", err)
 		metrics.RecordAuthLogin(false)
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid email or password",
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to process login",
 		})
 	}
+	if retryAfter > 0 {
+		metrics.RecordAuthLogin(false)
+		metrics.RecordAuthLoginThrottled(scope)
+		return tooManyLoginAttempts(c, retryAfter, "Too many failed login attempts, try again later")
+	}
 
-	// Check if user is verified
-	if !user.IsVerified {
+	if user == nil {
+		// Spend the same time as a wrong password would
+		utils.CheckDummyPasswordHash(req.Password)
+		recordLoginFailure(c, nil)
 		metrics.RecordAuthLogin(false)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Please verify your email before logging in",
+			"error": "Invalid email or password",
 		})
 	}
 
-	// Generate JWT tokens
-	accessToken, err := utils.GenerateAccessToken(user.ID, user.Email, user.Role)
-	if err != nil {
+	// Verify password
+	if !utils.CheckPasswordHash(req.Password, user.PasswordHash) {
 		metrics.RecordAuthLogin(false)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to generate access token",
+		if recordClaimedLoginFailure(c, user) {
+			return tooManyLoginAttempts(c, config.GetEnvDuration("LOGIN_LOCK_DURATION", 15*time.Minute),
+				"Account locked after too many failed login attempts")
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid email or password",
 		})
 	}
 
-	refreshToken, err := utils.GenerateRefreshToken(user.ID, user.Email)
-	if err != nil {
-		metrics.RecordAuthLogin(false)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to generate refresh token",
-		})
+	// A correct password clears the failure counter
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := models.ResetUserLoginFailures(user.ID); err != nil {
+			log.Printf("Failed to reset failed login counter: %v", err)
+		}
 	}
 
-	// Record JWT token generation metrics
-	metrics.RecordJWTTokenGenerated("access_token")
-	metrics.RecordJWTTokenGenerated("refresh_token")
+	// The plaintext is only available now, so this is when hashes made with
+	// an old algorithm or weaker parameters get upgraded
+	if utils.PasswordNeedsRehash(user.PasswordHash) {
+		if hash, err := utils.HashPassword(req.Password); err != nil {
+			log.Printf("Failed to rehash password: %v", err)
+		} else if updated, err := models.UpdatePasswordHashIfUnchanged(user.ID, user.PasswordHash, hash); err != nil {
+			log.Printf("Failed to store rehashed password: %v", err)
+		} else if updated {
+			user.PasswordHash = hash
+		}
+	}
 
-	// Hash and store refresh token
-	refreshTokenHash := utils.HashRefreshToken(refreshToken)
-	refreshExpiresAt := time.Now().Add(7 * 24 * time.Hour) // 7 days
-	
-	err = models.StoreRefreshToken(user.ID, refreshTokenHash, refreshExpiresAt)
-	if err != nil {
+	// Check if user is verified
+	if !user.IsVerified {
 		metrics.RecordAuthLogin(false)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to store refresh token",
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Please verify your email before logging in",
 		})
 	}
 
-	metrics.RecordAuthLogin(true)
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Login successful",
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
-		"token_type":    "Bearer",
-		"expires_in":    900, // 15 minutes in seconds
-		"user": fiber.Map{
-			"id":           user.ID,
-			"email":        user.Email,
-			"is_verified":  user.IsVerified,
-			"role":         user.Role,
-			"created_at":   user.CreatedAt,
-		},
-	})
+	// Ask for the second factor if enabled, otherwise issue tokens
+	return completeLogin(c, user, req.DeviceName)
 }
 
 type RefreshTokenRequest struct {
@@ -224,11 +297,38 @@ func RefreshToken(c *fiber.Ctx) error {
 		})
 	}
 
+	user, storedToken, ok, err := redeemRefreshToken(c, req.RefreshToken, "")
+	if !ok {
+		return err
+	}
+
+	// Generate and store new access and refresh tokens in the same family
+	tokens, message, err := issueTokenPair(c, user, storedToken, "")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Token refreshed successfully",
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    900, // 15 minutes in seconds
+	})
+}
+
+// redeemRefreshToken checks a refresh token and marks it rotated, ready for
+// issueTokenPair to add its successor to the family. When userID is set,
+// tokens of other users are rejected. Without ok the response has been
+// written.
+func redeemRefreshToken(c *fiber.Ctx, refreshToken, userID string) (*models.User, *models.RefreshToken, bool, error) {
 	// Hash the provided token and check if it exists in database
-	tokenHash := utils.HashRefreshToken(req.RefreshToken)
+	tokenHash := utils.HashRefreshToken(refreshToken)
 	storedToken, err := models.GetRefreshTokenByHash(tokenHash)
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+	if err != nil || storedToken.RevokedAt != nil || (userID != "" && storedToken.UserID != userID) {
+		return nil, nil, false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid refresh token",
 		})
 	}
@@ -237,65 +337,87 @@ func RefreshToken(c *fiber.Ctx) error {
 	if time.Now().After(storedToken.ExpiresAt) {
 		// Delete expired token
 		models.DeleteRefreshToken(tokenHash)
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		return nil, nil, false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Refresh token expired",
 		})
 	}
 
+	// A token that was already rotated is only accepted again within the
+	// grace window, so two concurrent refreshes from one client both succeed.
+	// Anything later is a replay: the whole family is revoked.
+	if storedToken.RotatedAt != nil && !withinRefreshGrace(*storedToken.RotatedAt) {
+		handleRefreshTokenReuse(c, storedToken)
+		return nil, nil, false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+
 	// Get user to ensure they still exist and are verified
 	user, err := models.GetUserByID(storedToken.UserID)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		return nil, nil, false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "User not found",
 		})
 	}
 
 	if !user.IsVerified {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		return nil, nil, false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "User not verified",
 		})
 	}
 
-	// Generate new access and refresh tokens
-	accessToken, err := utils.GenerateAccessToken(user.ID, user.Email, user.Role)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to generate access token",
-		})
+	if ok, err := accountActive(c, user); !ok {
+		return nil, nil, false, err
 	}
 
-	newRefreshToken, err := utils.GenerateRefreshToken(user.ID, user.Email)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to generate refresh token",
-		})
+	// Mark the old refresh token as used; it stays in the table so a later
+	// replay can be detected
+	if storedToken.RotatedAt == nil {
+		if _, err := models.MarkRefreshTokenRotated(storedToken.ID); err != nil {
+			return nil, nil, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to rotate refresh token",
+			})
+		}
 	}
 
-	// Hash and store new refresh token
-	newRefreshTokenHash := utils.HashRefreshToken(newRefreshToken)
-	newRefreshExpiresAt := time.Now().Add(7 * 24 * time.Hour) // 7 days
-	
-	err = models.StoreRefreshToken(user.ID, newRefreshTokenHash, newRefreshExpiresAt)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to store new refresh token",
-		})
+	return user, storedToken, true, nil
+}
+
+// withinRefreshGrace reports whether a rotated refresh token may still be
+// used (REFRESH_TOKEN_REUSE_GRACE, default 10s)
+func withinRefreshGrace(rotatedAt time.Time) bool {
+	grace := config.GetEnvDuration("REFRESH_TOKEN_REUSE_GRACE", 10*time.Second)
+	return time.Since(rotatedAt) <= grace
+}
+
+// handleRefreshTokenReuse revokes a refresh token family after one of its
+// rotated tokens was replayed, records a security event and, unless
+// REFRESH_TOKEN_REUSE_NOTIFY is false, emails the user
+func handleRefreshTokenReuse(c *fiber.Ctx, token *models.RefreshToken) {
+	if err := models.RevokeRefreshTokenFamily(token.FamilyID); err != nil {
+		log.Printf("Failed to revoke refresh token family %s: %v", token.FamilyID, err)
+	}
+
+	recordSecurityEvent(c, token.UserID, "refresh_token.reuse_detected", fiber.Map{
+		"family_id": token.FamilyID,
+		"token_id":  token.ID,
+	})
+
+	if !config.GetEnvBool("REFRESH_TOKEN_REUSE_NOTIFY", true) {
+		return
 	}
 
-	// Delete the old refresh token
-	err = models.DeleteRefreshToken(tokenHash)
+	user, err := models.GetUserByID(token.UserID)
 	if err != nil {
-		// Log the error but don't fail the request
-		log.Printf("Failed to delete old refresh token: %v", err)
+		return
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Token refreshed successfully",
-		"access_token":  accessToken,
-		"refresh_token": newRefreshToken,
-		"token_type":    "Bearer",
-		"expires_in":    900, // 15 minutes in seconds
-	})
+	err = kafka.SendSecurityAlertEmail(user.Email, "Suspicious sign-in activity",
+		"A refresh token that had already been used was presented again, so we signed out that session.")
+	metrics.RecordEmailSent("security_alert", err == nil)
+	if err != nil {
+		log.Printf("Failed to send security alert email: %v", err)
+	}
 }
 
 type LogoutRequest struct {
@@ -348,11 +470,14 @@ func ForgotPassword(c *fiber.Ctx) error {
 		})
 	}
 
-	// Generate OTP for password reset
-	otp := utils.GenerateOTP()
-	
-	// Store OTP with a different purpose (password reset)
-	err = models.StorePasswordResetOTP(req.Email, otp)
+	// Generate and store a password reset OTP
+	otp, err := models.IssueCode(models.PurposeResetPassword, req.Email, nil)
+	if errors.Is(err, models.ErrCodeRecentlyIssued) || errors.Is(err, models.ErrCodeDailyLimit) {
+		// Same answer as for unknown emails; the earlier code is still valid
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"message": "If the email exists in our system, you will receive a password reset OTP",
+		})
+	}
 	if err != nil {
 		metrics.RecordAuthPasswordReset(false)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -394,14 +519,6 @@ func ResetPassword(c *fiber.Ctx) error {
 		})
 	}
 
-	// Validate password strength (minimum 6 characters)
-	if len(req.NewPassword) < 6 {
-		metrics.RecordAuthPasswordReset(false)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Password must be at least 6 characters long",
-		})
-	}
-
 	// Check if user exists
 	user, err := models.GetUserByEmail(req.Email)
 	if err != nil {
@@ -419,13 +536,24 @@ func ResetPassword(c *fiber.Ctx) error {
 		})
 	}
 
+	// Validate password strength before the code is spent
+	if ok, err := checkNewPassword(c, req.NewPassword, user.Email, user.DisplayName, user.GivenName, user.FamilyName); !ok {
+		metrics.RecordAuthPasswordReset(false)
+		return err
+	}
+
 	// Verify password reset OTP
-	err = models.VerifyPasswordResetOTP(req.Email, req.OTP)
+	_, err = models.VerifyCode(models.PurposeResetPassword, req.Email, req.OTP)
 	if err != nil {
 		metrics.RecordAuthPasswordReset(false)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return otpError(c, err)
+	}
+
+	// Reuse is only checked once the code is verified, so the reset form
+	// cannot be used to test guesses of old passwords
+	if ok, err := checkPasswordReuse(c, user, req.NewPassword); !ok {
+		metrics.RecordAuthPasswordReset(false)
+		return err
 	}
 
 	// Hash the new password
@@ -438,7 +566,7 @@ func ResetPassword(c *fiber.Ctx) error {
 	}
 
 	// Update user's password
-	err = models.UpdateUserPassword(req.Email, hashedPassword)
+	err = models.ChangeUserPassword(user.ID, hashedPassword, passwordHistorySize())
 	if err != nil {
 		metrics.RecordAuthPasswordReset(false)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -453,12 +581,38 @@ func ResetPassword(c *fiber.Ctx) error {
 		log.Printf("Failed to delete refresh tokens: %v", err)
 	}
 
+	// Revoke access tokens too so existing sessions lose access immediately
+	err = models.RevokeAllAccessTokensForUser(user.ID)
+	if err != nil {
+		log.Printf("Failed to revoke access tokens: %v", err)
+	}
+
 	metrics.RecordAuthPasswordReset(true)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Password reset successfully. Please login with your new password.",
 	})
 }
 
+// otpError answers a failed OTP verification. Too many wrong guesses get a
+// distinct 429 so clients know to request a new code.
+func otpError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, models.ErrCodeTooManyAttempts):
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, models.ErrCodeNotFound), errors.Is(err, models.ErrCodeExpired), errors.Is(err, models.ErrCodeInvalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		log.Printf("Failed to verify OTP: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify OTP",
+		})
+	}
+}
+
 func Logout(c *fiber.Ctx) error {
 	var req LogoutRequest
 
@@ -474,9 +628,19 @@ func Logout(c *fiber.Ctx) error {
 		})
 	}
 
-	// Hash the refresh token and delete it from database
+	// Revoke the access token as well when the client sends it along
+	if accessToken := middleware.BearerToken(c); accessToken != "" {
+		if err := models.RevokeAccessToken(utils.HashToken(accessToken)); err != nil {
+			log.Printf("Failed to revoke access token: %v", err)
+		}
+	}
+
+	// Revoke the whole session the refresh token belongs to
 	tokenHash := utils.HashRefreshToken(req.RefreshToken)
-	err := models.DeleteRefreshToken(tokenHash)
+	storedToken, err := models.GetRefreshTokenByHash(tokenHash)
+	if err == nil {
+		err = models.RevokeRefreshTokenFamily(storedToken.FamilyID)
+	}
 	if err != nil {
 		// Token might not exist, but we still return success for security
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
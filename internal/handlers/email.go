@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/kafka"
+	"auth-api/internal/metrics"
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"errors"
+	"log"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"new_email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Code string `json:"code"`
+}
+
+type CancelEmailChangeRequest struct {
+	UserID string `json:"user_id"`
+	Token  string `json:"token"`
+}
+
+// ChangeEmail starts an email change. A confirmation code goes to the new
+// address and a notice with a cancel link to the current one; nothing changes
+// until the code is confirmed.
+func ChangeEmail(c *fiber.Ctx) error {
+	var req ChangeEmailRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	if req.NewEmail == "" || req.CurrentPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "New email and current password are required",
+		})
+	}
+
+	user, err := models.GetUserByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if ok, err := verifyCurrentPassword(c, user, req.CurrentPassword); !ok {
+		return err
+	}
+
+	if req.NewEmail == user.Email {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "New email is the same as the current one",
+		})
+	}
+
+	if _, err := models.GetUserByEmail(req.NewEmail); err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": models.ErrEmailTaken.Error(),
+		})
+	}
+
+	code, err := models.IssueCode(models.PurposeChangeEmail, user.ID, map[string]string{
+		"new_email": req.NewEmail,
+	})
+	if errors.Is(err, models.ErrCodeRecentlyIssued) || errors.Is(err, models.ErrCodeDailyLimit) {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to issue email change code: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start email change",
+		})
+	}
+
+	cancelToken, err := models.IssueCode(models.PurposeCancelEmailChange, user.ID, nil)
+	if err != nil && !errors.Is(err, models.ErrCodeRecentlyIssued) {
+		log.Printf("Failed to issue email change cancel token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start email change",
+		})
+	}
+
+	if err := kafka.SendEmailChangeCodeEmail(req.NewEmail, code); err != nil {
+		metrics.RecordEmailSent("email_change", false)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send confirmation email",
+		})
+	}
+	metrics.RecordEmailSent("email_change", true)
+
+	// Without a fresh cancel token the notice from the previous request
+	// still has a working link
+	if cancelToken != "" {
+		if err := kafka.SendEmailChangeNoticeEmail(user.Email, req.NewEmail, emailChangeCancelURL(user.ID, cancelToken)); err != nil {
+			log.Printf("Failed to send email change notice: %v", err)
+			metrics.RecordEmailSent("email_change_notice", false)
+		} else {
+			metrics.RecordEmailSent("email_change_notice", true)
+		}
+	}
+
+	recordSecurityEvent(c, user.ID, "email.change_requested", fiber.Map{
+		"new_email": req.NewEmail,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "A confirmation code has been sent to the new email address",
+	})
+}
+
+// ConfirmEmailChange swaps the address after the code sent to it is
+// confirmed. Access tokens carrying the old email are revoked everywhere and
+// the caller gets a new token pair; other sessions pick up the new email on
+// their next refresh.
+func ConfirmEmailChange(c *fiber.Ctx) error {
+	var req ConfirmEmailChangeRequest
+
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code is required",
+		})
+	}
+
+	user, err := models.GetUserByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	data, err := models.VerifyCode(models.PurposeChangeEmail, user.ID, req.Code)
+	if err != nil {
+		return otpError(c, err)
+	}
+
+	oldEmail, newEmail := user.Email, data["new_email"]
+	if err := models.UpdateUserEmail(user.ID, newEmail); err != nil {
+		if errors.Is(err, models.ErrEmailTaken) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to update email: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update email",
+		})
+	}
+	user.Email = newEmail
+	user.IsVerified = true
+
+	if err := models.DeleteCode(models.PurposeCancelEmailChange, user.ID); err != nil {
+		log.Printf("Failed to delete email change cancel token: %v", err)
+	}
+
+	// Access tokens embed the email claim
+	if err := models.RevokeAllAccessTokensForUser(user.ID); err != nil {
+		log.Printf("Failed to revoke access tokens: %v", err)
+	}
+
+	// Replace the current session with one issued for the new email,
+	// keeping its device name
+	var deviceName string
+	if sessionID := middleware.GetSessionID(c); sessionID != "" {
+		if sessions, err := models.ListSessionsForUser(user.ID); err == nil {
+			for _, session := range sessions {
+				if session.ID == sessionID {
+					deviceName = session.DeviceName
+				}
+			}
+		}
+		if err := models.RevokeRefreshTokenFamily(sessionID); err != nil {
+			log.Printf("Failed to revoke current session: %v", err)
+		}
+	}
+	tokens, message, err := issueTokenPair(c, user, nil, deviceName)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	recordSecurityEvent(c, user.ID, "email.changed", fiber.Map{
+		"old_email": oldEmail,
+		"new_email": newEmail,
+	})
+
+	if err := kafka.SendSecurityAlertEmail(oldEmail, "Your email address was changed",
+		"The email address of your account was changed to "+newEmail+"."); err != nil {
+		log.Printf("Failed to send email changed alert: %v", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       "Email changed successfully",
+		"email":         newEmail,
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    900, // 15 minutes in seconds
+	})
+}
+
+// CancelEmailChange drops a pending email change using the link sent to the
+// current address. It needs no login since the link may be the only thing
+// the real owner has left.
+func CancelEmailChange(c *fiber.Ctx) error {
+	var req CancelEmailChangeRequest
+
+	if err := c.BodyParser(&req); err != nil || req.UserID == "" || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "User ID and token are required",
+		})
+	}
+
+	if _, err := models.VerifyCode(models.PurposeCancelEmailChange, req.UserID, req.Token); err != nil {
+		return otpError(c, err)
+	}
+
+	if err := models.DeleteCode(models.PurposeChangeEmail, req.UserID); err != nil {
+		log.Printf("Failed to delete email change code: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to cancel email change",
+		})
+	}
+
+	recordSecurityEvent(c, req.UserID, "email.change_cancelled", nil)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Email change cancelled. If you didn't request it, reset your password.",
+	})
+}
+
+// emailChangeCancelURL builds the cancel link sent to the current address.
+// The page at EMAIL_CHANGE_CANCEL_URL is expected to post user_id and token
+// to /auth/email-change/cancel.
+func emailChangeCancelURL(userID, token string) string {
+	query := url.Values{}
+	query.Set("user_id", userID)
+	query.Set("token", token)
+	return config.GetEnv("EMAIL_CHANGE_CANCEL_URL", "http://localhost:3000/account/email/cancel") + "?" + query.Encode()
+}
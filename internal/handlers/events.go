@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"auth-api/internal/kafka"
+	"auth-api/internal/metrics"
+	"auth-api/internal/models"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// recordSecurityEvent writes an audit event for the request and publishes it
+// to the events topic. Failures are logged and never fail the request.
+func recordSecurityEvent(c *fiber.Ctx, userID, eventType string, details fiber.Map) {
+	event := &models.AuditEvent{
+		EventType: eventType,
+		IPAddress: c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		Details:   details,
+	}
+	if userID != "" {
+		event.UserID = &userID
+	}
+
+	if err := models.CreateAuditEvent(event); err != nil {
+		log.Printf("Failed to store audit event %s: %v", eventType, err)
+	}
+
+	if err := kafka.PublishEvent(eventType, userID, details); err != nil {
+		log.Printf("Failed to publish event %s: %v", eventType, err)
+	}
+
+	metrics.RecordSecurityEvent(eventType)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ExportAccountData returns everything held about the current user as JSON,
+// or as a zip with ?format=zip. Small exports are sent right away; when the
+// user has more than EXPORT_SYNC_MAX_RECORDS sessions and audit events, or
+// ?async=true is given, an export job is queued instead and the download link
+// is emailed once it is ready.
+func ExportAccountData(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	format := c.Query("format", models.ExportFormatJSON)
+	if format != models.ExportFormatJSON && format != models.ExportFormatZip {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Format must be json or zip",
+		})
+	}
+
+	async := c.QueryBool("async")
+	if !async {
+		count, err := models.CountUserDataRecords(userID)
+		if err != nil {
+			log.Printf("Failed to count user data: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to export data",
+			})
+		}
+		async = count > config.GetEnvInt("EXPORT_SYNC_MAX_RECORDS", 1000)
+	}
+
+	if async {
+		return queueDataExport(c, userID, format)
+	}
+
+	export, err := models.CollectUserData(userID)
+	if err != nil {
+		log.Printf("Failed to collect user data: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to export data",
+		})
+	}
+
+	archive, err := export.Archive(format)
+	if err != nil {
+		log.Printf("Failed to build data export: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to export data",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "account.exported", fiber.Map{
+		"format": format,
+	})
+
+	return sendDataExport(c, format, export.ExportedAt, archive)
+}
+
+// queueDataExport answers 202 with the export job, reusing one that is
+// already queued or running for the user
+func queueDataExport(c *fiber.Ctx, userID, format string) error {
+	job, err := models.GetActiveDataExport(userID)
+	if err != nil && !models.IsNotFound(err) {
+		log.Printf("Failed to look up data export: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to export data",
+		})
+	}
+
+	if job == nil {
+		job, err = models.CreateDataExport(userID, format)
+		if err != nil {
+			log.Printf("Failed to queue data export: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to export data",
+			})
+		}
+		recordSecurityEvent(c, userID, "account.export_requested", fiber.Map{
+			"export_id": job.ID,
+			"format":    format,
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Your export is being prepared. A download link will be emailed to you.",
+		"export":  job,
+	})
+}
+
+// GetDataExport returns the status of one of the current user's export jobs
+func GetDataExport(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !utils.IsUUID(id) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Export not found",
+		})
+	}
+
+	job, err := models.GetDataExport(middleware.GetUserID(c), id)
+	if err != nil {
+		if models.IsNotFound(err) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Export not found",
+			})
+		}
+		log.Printf("Failed to get data export: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get export",
+		})
+	}
+
+	return c.JSON(job)
+}
+
+// DownloadDataExport serves a finished export archive. It is opened from the
+// emailed link, so the token in the link authenticates the download instead
+// of a login.
+func DownloadDataExport(c *fiber.Ctx) error {
+	id, token := c.Params("id"), c.Query("token")
+	if !utils.IsUUID(id) || token == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Export not found or expired",
+		})
+	}
+
+	job, archive, err := models.GetDataExportArchive(id, utils.HashToken(token))
+	if err != nil {
+		if models.IsNotFound(err) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Export not found or expired",
+			})
+		}
+		log.Printf("Failed to get data export archive: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to download export",
+		})
+	}
+
+	recordSecurityEvent(c, job.UserID, "account.exported", fiber.Map{
+		"export_id": job.ID,
+		"format":    job.Format,
+	})
+
+	return sendDataExport(c, job.Format, *job.CompletedAt, archive)
+}
+
+// sendDataExport writes an export archive as a file download
+func sendDataExport(c *fiber.Ctx, format string, exportedAt time.Time, archive []byte) error {
+	filename := "account-export-" + strconv.FormatInt(exportedAt.Unix(), 10) + "." + format
+	// Attachment also sets the content type from the file extension
+	c.Attachment(filename)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Send(archive)
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"auth-api/internal/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// JWKS publishes the public keys that verify our access tokens so other
+// services can check tokens without holding the signing keys
+func JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(fiber.StatusOK).JSON(utils.PublicJWKS())
+}
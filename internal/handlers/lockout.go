@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/kafka"
+	"auth-api/internal/metrics"
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// loginBackoff returns how long a user with the given number of consecutive
+// failures has to wait after the last one. The delay starts at
+// LOGIN_BACKOFF_BASE once LOGIN_BACKOFF_THRESHOLD is reached and doubles on
+// every further failure, up to LOGIN_BACKOFF_MAX.
+func loginBackoff(failures int) time.Duration {
+	threshold := config.GetEnvInt("LOGIN_BACKOFF_THRESHOLD", 3)
+	if failures < threshold {
+		return 0
+	}
+
+	base := config.GetEnvDuration("LOGIN_BACKOFF_BASE", time.Second)
+	maxDelay := config.GetEnvDuration("LOGIN_BACKOFF_MAX", 5*time.Minute)
+
+	delay := time.Duration(float64(base) * math.Pow(2, float64(failures-threshold)))
+	if delay <= 0 || delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// loginRetryAfter returns how long the client has to wait before it may try
+// to log in again, and whether the wait comes from the IP or the account.
+// The user may be nil when the email is unknown.
+func loginRetryAfter(c *fiber.Ctx, user *models.User) (time.Duration, string, error) {
+	now := time.Now()
+
+	state, err := models.GetLoginIPState(c.IP())
+	if err != nil && !models.IsNotFound(err) {
+		return 0, "", err
+	}
+	if state != nil && state.BlockedUntil != nil && now.Before(*state.BlockedUntil) {
+		return state.BlockedUntil.Sub(now), "ip", nil
+	}
+
+	if user == nil {
+		return 0, "", nil
+	}
+
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return user.LockedUntil.Sub(now), "user", nil
+	}
+
+	if user.LastFailedLoginAt != nil {
+		if next := user.LastFailedLoginAt.Add(loginBackoff(user.FailedLoginAttempts)); now.Before(next) {
+			return next.Sub(now), "user", nil
+		}
+	}
+
+	return 0, "", nil
+}
+
+// claimLoginAttempt is loginRetryAfter for a credential that is about to be
+// checked. When the client may try, the attempt is counted against the
+// account up front and refused once LOGIN_LOCK_THRESHOLD attempts are
+// counted, so a burst of parallel requests cannot get past the lock. The new
+// count is kept in user.FailedLoginAttempts: a correct credential clears it
+// with ResetUserLoginFailures, a wrong one goes to recordClaimedLoginFailure.
+func claimLoginAttempt(c *fiber.Ctx, user *models.User) (time.Duration, string, error) {
+	retryAfter, scope, err := loginRetryAfter(c, user)
+	if err != nil || retryAfter > 0 || user == nil {
+		return retryAfter, scope, err
+	}
+
+	attempts, ok, err := models.ClaimUserLoginAttempt(user.ID, loginLockThreshold())
+	if err != nil {
+		return 0, "", err
+	}
+	if !ok {
+		// Attempts in flight are about to lock the account
+		return config.GetEnvDuration("LOGIN_LOCK_DURATION", 15*time.Minute), "user", nil
+	}
+	user.FailedLoginAttempts = attempts
+	return 0, "", nil
+}
+
+// recordLoginFailure counts a failed login against the client IP and, when
+// known, the account. It blocks the IP or locks the account once their
+// threshold is reached and reports whether the account was locked.
+func recordLoginFailure(c *fiber.Ctx, user *models.User) bool {
+	recordIPLoginFailure(c)
+	if user == nil {
+		return false
+	}
+
+	attempts, err := models.RecordUserLoginFailure(user.ID)
+	if err != nil {
+		log.Printf("Failed to record failed login for user %s: %v", user.ID, err)
+		return false
+	}
+	return lockAfterLoginFailures(c, user, attempts)
+}
+
+// recordClaimedLoginFailure is recordLoginFailure for an attempt that
+// claimLoginAttempt has already counted against the account
+func recordClaimedLoginFailure(c *fiber.Ctx, user *models.User) bool {
+	recordIPLoginFailure(c)
+	if user == nil {
+		return false
+	}
+	return lockAfterLoginFailures(c, user, user.FailedLoginAttempts)
+}
+
+func loginLockThreshold() int {
+	return config.GetEnvInt("LOGIN_LOCK_THRESHOLD", 10)
+}
+
+// recordIPLoginFailure counts a failed login against the client IP and
+// blocks it once LOGIN_IP_LIMIT is reached
+func recordIPLoginFailure(c *fiber.Ctx) {
+	ip := c.IP()
+	ipAttempts, err := models.RecordIPLoginFailure(ip, config.GetEnvDuration("LOGIN_IP_WINDOW", 15*time.Minute))
+	if err != nil {
+		log.Printf("Failed to record failed login for IP %s: %v", ip, err)
+		return
+	}
+	if ipAttempts < config.GetEnvInt("LOGIN_IP_LIMIT", 50) {
+		return
+	}
+
+	until := time.Now().Add(config.GetEnvDuration("LOGIN_IP_BLOCK_DURATION", 15*time.Minute))
+	if err := models.BlockIP(ip, until); err != nil {
+		log.Printf("Failed to block IP %s: %v", ip, err)
+		return
+	}
+	metrics.RecordAuthLockout("ip")
+	recordSecurityEvent(c, "", "login.ip_blocked", fiber.Map{
+		"blocked_until": until,
+		"attempts":      ipAttempts,
+	})
+}
+
+// lockAfterLoginFailures locks the account once attempts reaches
+// LOGIN_LOCK_THRESHOLD and reports whether it did
+func lockAfterLoginFailures(c *fiber.Ctx, user *models.User, attempts int) bool {
+	if attempts < loginLockThreshold() {
+		return false
+	}
+
+	until := time.Now().Add(config.GetEnvDuration("LOGIN_LOCK_DURATION", 15*time.Minute))
+	if err := models.LockUser(user.ID, until); err != nil {
+		log.Printf("Failed to lock user %s: %v", user.ID, err)
+		return false
+	}
+
+	metrics.RecordAuthLockout("user")
+	recordSecurityEvent(c, user.ID, "account.locked", fiber.Map{
+		"locked_until": until,
+		"attempts":     attempts,
+	})
+
+	if err := kafka.SendAccountLockedEmail(user.Email, until); err != nil {
+		log.Printf("Failed to send account locked email: %v", err)
+	}
+	return true
+}
+
+// tooManyLoginAttempts rejects a login with 429 and a Retry-After header
+func tooManyLoginAttempts(c *fiber.Ctx, retryAfter time.Duration, message string) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+// UnlockUser clears an account lock and its failed login counter
+func UnlockUser(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+
+	if err := models.ResetUserLoginFailures(user.ID); err != nil {
+		log.Printf("Failed to unlock user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlock user",
+		})
+	}
+
+	recordSecurityEvent(c, user.ID, "account.unlocked", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User unlocked",
+		"user_id": user.ID,
+	})
+}
@@ -0,0 +1,482 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/metrics"
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const recoveryCodeCount = 10
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type DisableTOTPRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// secondFactors returns the second factor methods a user has set up
+func secondFactors(userID string) ([]string, error) {
+	var methods []string
+
+	totpEnabled, err := models.IsTOTPEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totpEnabled {
+		methods = append(methods, "totp", "recovery_code")
+	}
+
+	keys, err := models.CountWebAuthnCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	if keys > 0 {
+		methods = append(methods, "webauthn")
+	}
+
+	return methods, nil
+}
+
+// startMFAChallenge answers a login whose password was correct with a
+// short-lived challenge token instead of real tokens
+func startMFAChallenge(c *fiber.Ctx, user *models.User, deviceName string, methods []string) error {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start two-factor authentication",
+		})
+	}
+
+	ttl := config.GetEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute)
+	challenge := &models.MFAChallenge{
+		UserID:     user.ID,
+		TokenHash:  utils.HashToken(token),
+		DeviceName: deviceName,
+		ExpiresAt:  time.Now().Add(ttl),
+	}
+	if err := models.CreateMFAChallenge(challenge); err != nil {
+		log.Printf("Failed to store MFA challenge: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start two-factor authentication",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":      "Two-factor authentication required",
+		"mfa_required": true,
+		"mfa_token":    token,
+		"methods":      methods,
+		"expires_in":   int(ttl.Seconds()),
+	})
+}
+
+// VerifyMFA exchanges an MFA challenge token and a TOTP or recovery code for
+// the access/refresh token pair
+func VerifyMFA(c *fiber.Ctx) error {
+	var req MFAVerifyRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "MFA token and a code or recovery code are required",
+		})
+	}
+
+	challenge, err := models.GetMFAChallengeByHash(utils.HashToken(req.MFAToken))
+	if err != nil || time.Now().After(challenge.ExpiresAt) {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired MFA token",
+		})
+	}
+
+	user, err := models.GetUserByID(challenge.UserID)
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired MFA token",
+		})
+	}
+
+	// The account lock and backoff apply to second factors as well, so new
+	// challenges from repeated password logins do not give fresh guesses
+	retryAfter, scope, err := loginRetryAfter(c, user)
+	if err != nil {
+		log.Printf("Failed to check login throttling: %v", err)
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to process login",
+		})
+	}
+	if retryAfter > 0 {
+		metrics.RecordAuthLogin(false)
+		metrics.RecordAuthLoginThrottled(scope)
+		return tooManyLoginAttempts(c, retryAfter, "Too many failed login attempts, try again later")
+	}
+
+	if ok, err := claimMFAAttempt(c, challenge); !ok {
+		return err
+	}
+
+	var ok bool
+	if req.RecoveryCode != "" {
+		ok, err = models.UseRecoveryCode(user.ID, utils.HashToken(utils.NormalizeRecoveryCode(req.RecoveryCode)))
+		if ok {
+			recordSecurityEvent(c, user.ID, "mfa.recovery_code_used", nil)
+		}
+	} else {
+		ok, err = checkTOTPCode(user.ID, req.Code)
+	}
+	if err != nil {
+		log.Printf("Failed to verify second factor: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify code",
+		})
+	}
+
+	if !ok {
+		metrics.RecordAuthLogin(false)
+		if recordLoginFailure(c, user) {
+			models.DeleteMFAChallenge(challenge.ID)
+			return tooManyLoginAttempts(c, config.GetEnvDuration("LOGIN_LOCK_DURATION", 15*time.Minute),
+				"Account locked after too many failed login attempts")
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	if err := models.DeleteMFAChallenge(challenge.ID); err != nil {
+		log.Printf("Failed to delete MFA challenge: %v", err)
+	}
+	if user.FailedLoginAttempts > 0 {
+		if err := models.ResetUserLoginFailures(user.ID); err != nil {
+			log.Printf("Failed to reset failed login counter: %v", err)
+		}
+	}
+
+	return respondWithTokens(c, user, challenge.DeviceName)
+}
+
+// claimMFAAttempt uses up one attempt of the challenge before its code is
+// checked. Once MFA_MAX_ATTEMPTS are used the challenge is discarded and the
+// user has to log in again. Without ok the response has been written.
+func claimMFAAttempt(c *fiber.Ctx, challenge *models.MFAChallenge) (bool, error) {
+	claimed, err := models.ClaimMFAChallengeAttempt(challenge.ID, config.GetEnvInt("MFA_MAX_ATTEMPTS", 5))
+	if err != nil {
+		log.Printf("Failed to record MFA attempt: %v", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify code",
+		})
+	}
+	if !claimed {
+		models.DeleteMFAChallenge(challenge.ID)
+		metrics.RecordAuthLogin(false)
+		return false, c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many attempts, please log in again",
+		})
+	}
+	return true, nil
+}
+
+// GetMFAStatus returns the current user's two-factor settings
+func GetMFAStatus(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	enabled, err := models.IsTOTPEnabled(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load two-factor settings",
+		})
+	}
+
+	remaining, err := models.CountUnusedRecoveryCodes(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load two-factor settings",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"totp_enabled":             enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// SetupTOTP starts TOTP enrollment by creating a secret. It only takes effect
+// once ConfirmTOTP receives a valid code.
+func SetupTOTP(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	enabled, err := models.IsTOTPEnabled(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load two-factor settings",
+		})
+	}
+	if enabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication is already enabled",
+		})
+	}
+
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate secret",
+		})
+	}
+
+	if err := models.SaveTOTPSecret(userID, secret); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store secret",
+		})
+	}
+
+	issuer := config.GetEnv("MFA_ISSUER", "auth-api")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Scan the QR code with your authenticator app, then confirm with a code",
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(issuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP enables TOTP after the first valid code and returns the
+// recovery codes. They are only shown once.
+func ConfirmTOTP(c *fiber.Ctx) error {
+	var req MFACodeRequest
+	userID := middleware.GetUserID(c)
+
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code is required",
+		})
+	}
+
+	totp, err := models.GetUserTOTP(userID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Two-factor setup has not been started",
+		})
+	}
+	if totp.Enabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Two-factor authentication is already enabled",
+		})
+	}
+
+	ok, err := checkTOTPCode(userID, req.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify code",
+		})
+	}
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	if err := models.EnableTOTP(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to enable two-factor authentication",
+		})
+	}
+
+	codes, err := replaceRecoveryCodes(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate recovery codes",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "mfa.enabled", fiber.Map{"method": "totp"})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTP turns TOTP off after checking the password and a current code
+func DisableTOTP(c *fiber.Ctx) error {
+	var req DisableTOTPRequest
+	userID := middleware.GetUserID(c)
+
+	if err := c.BodyParser(&req); err != nil || req.Password == "" || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Password and code are required",
+		})
+	}
+
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if ok, err := verifyCurrentPassword(c, user, req.Password); !ok {
+		return err
+	}
+	if ok, err := verifyCurrentTOTP(c, user, req.Code); !ok {
+		return err
+	}
+
+	if err := models.DisableTOTP(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to disable two-factor authentication",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "mfa.disabled", fiber.Map{"method": "totp"})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a TOTP code
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var req MFACodeRequest
+	userID := middleware.GetUserID(c)
+
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code is required",
+		})
+	}
+
+	enabled, err := models.IsTOTPEnabled(userID)
+	if err != nil || !enabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Two-factor authentication is not enabled",
+		})
+	}
+
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if ok, err := verifyCurrentTOTP(c, user, req.Code); !ok {
+		return err
+	}
+
+	codes, err := replaceRecoveryCodes(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate recovery codes",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "mfa.recovery_codes_regenerated", nil)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":        "Recovery codes regenerated",
+		"recovery_codes": codes,
+	})
+}
+
+// checkTOTPCode validates a TOTP code and marks its time step as used
+func checkTOTPCode(userID, code string) (bool, error) {
+	totp, err := models.GetUserTOTP(userID)
+	if err != nil {
+		if models.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	step, ok := utils.ValidateTOTP(totp.Secret, code, totp.LastUsedStep)
+	if !ok {
+		return false, nil
+	}
+
+	return models.UseTOTPStep(userID, step)
+}
+
+// verifyCurrentTOTP re-authenticates a logged-in user with a TOTP code before
+// a sensitive change. Like verifyCurrentPassword, wrong codes count as failed
+// logins so they cannot be guessed with a stolen access token. When the check
+// fails the response is already written and ok is false.
+func verifyCurrentTOTP(c *fiber.Ctx, user *models.User, code string) (bool, error) {
+	retryAfter, scope, err := claimLoginAttempt(c, user)
+	if err != nil {
+		log.Printf("Failed to check login throttling: %v", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify code",
+		})
+	}
+	if retryAfter > 0 {
+		metrics.RecordAuthLoginThrottled(scope)
+		return false, tooManyLoginAttempts(c, retryAfter, "Too many failed attempts, try again later")
+	}
+
+	ok, err := checkTOTPCode(user.ID, code)
+	if err != nil {
+		log.Printf("Failed to verify TOTP code: %v", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify code",
+		})
+	}
+	if !ok {
+		recordClaimedLoginFailure(c, user)
+		return false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	if user.FailedLoginAttempts > 0 {
+		if err := models.ResetUserLoginFailures(user.ID); err != nil {
+			log.Printf("Failed to reset failed login counter: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes and stores their hashes
+func replaceRecoveryCodes(userID string) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(utils.NormalizeRecoveryCode(code))
+	}
+
+	if err := models.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type TokenRequest struct {
+	Token         string `form:"token" json:"token"`
+	TokenTypeHint string `form:"token_type_hint" json:"token_type_hint"`
+}
+
+type CreateOAuthClientRequest struct {
+	Name string `json:"name"`
+}
+
+// Introspect implements RFC 7662 token introspection for access and refresh tokens
+func Introspect(c *fiber.Ctx) error {
+	var req TokenRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":             "invalid_request",
+			"error_description": "token is required",
+		})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	// Try the hinted type first, then fall back to the other one
+	lookups := []func(string) fiber.Map{introspectAccessToken, introspectRefreshToken}
+	if req.TokenTypeHint == "refresh_token" {
+		lookups = []func(string) fiber.Map{introspectRefreshToken, introspectAccessToken}
+	}
+
+	for _, lookup := range lookups {
+		if response := lookup(req.Token); response != nil {
+			response["active"] = true
+			response["client_id"] = middleware.GetClientID(c)
+			return c.Status(fiber.StatusOK).JSON(response)
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"active": false})
+}
+
+// Revoke implements RFC 7009 token revocation. The response is 200 whether or
+// not the token was valid, so callers cannot use it to probe tokens.
+func Revoke(c *fiber.Ctx) error {
+	var req TokenRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":             "invalid_request",
+			"error_description": "token is required",
+		})
+	}
+
+	tokenHash := utils.HashToken(req.Token)
+
+	if storedToken, err := models.GetRefreshTokenByHash(tokenHash); err == nil {
+		// Revoking a refresh token ends the whole session
+		if err := models.RevokeRefreshTokenFamily(storedToken.FamilyID); err != nil {
+			log.Printf("Failed to revoke refresh token family: %v", err)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "temporarily_unavailable",
+			})
+		}
+		recordSecurityEvent(c, storedToken.UserID, "token.revoked", fiber.Map{
+			"token_type": "refresh_token",
+			"client_id":  middleware.GetClientID(c),
+		})
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	if err := models.RevokeAccessToken(tokenHash); err != nil {
+		log.Printf("Failed to revoke access token: %v", err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "temporarily_unavailable",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// introspectAccessToken returns the introspection claims for an active access token
+func introspectAccessToken(token string) fiber.Map {
+	claims, err := utils.ValidateToken(token)
+	if err != nil {
+		return nil
+	}
+
+	active, err := models.IsAccessTokenActive(utils.HashToken(token))
+	if err != nil || !active {
+		return nil
+	}
+
+	return fiber.Map{
+		"token_type": "access_token",
+		"scope":      claims.Role,
+		"sub":        claims.UserID,
+		"username":   claims.Email,
+		"role":       claims.Role,
+		"sid":        claims.SessionID,
+		"jti":        claims.ID,
+		"iss":        claims.Issuer,
+		"exp":        claims.ExpiresAt.Unix(),
+		"iat":        claims.IssuedAt.Unix(),
+	}
+}
+
+// introspectRefreshToken returns the introspection claims for a refresh token
+// that is stored, unexpired, not yet rotated and not revoked
+func introspectRefreshToken(token string) fiber.Map {
+	storedToken, err := models.GetRefreshTokenByHash(utils.HashRefreshToken(token))
+	if err != nil {
+		return nil
+	}
+
+	if storedToken.RevokedAt != nil || storedToken.RotatedAt != nil || time.Now().After(storedToken.ExpiresAt) {
+		return nil
+	}
+
+	user, err := models.GetUserByID(storedToken.UserID)
+	if err != nil {
+		return nil
+	}
+
+	return fiber.Map{
+		"token_type": "refresh_token",
+		"scope":      user.Role,
+		"sub":        user.ID,
+		"username":   user.Email,
+		"role":       user.Role,
+		"sid":        storedToken.FamilyID,
+		"iss":        "auth-api",
+		"exp":        storedToken.ExpiresAt.Unix(),
+		"iat":        storedToken.CreatedAt.Unix(),
+	}
+}
+
+// CreateOAuthClient registers a client for introspection/revocation and
+// returns its secret. The secret is only shown once.
+func CreateOAuthClient(c *fiber.Ctx) error {
+	var req CreateOAuthClientRequest
+	if err := c.BodyParser(&req); err != nil || req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required",
+		})
+	}
+
+	clientID, err := utils.GenerateRandomToken(12)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate client credentials",
+		})
+	}
+
+	clientSecret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate client credentials",
+		})
+	}
+
+	client, err := models.CreateOAuthClient(clientID, utils.HashToken(clientSecret), req.Name)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create client",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":       "Client created successfully",
+		"client":        client,
+		"client_secret": clientSecret,
+	})
+}
+
+// ListOAuthClients lists the registered OAuth clients
+func ListOAuthClients(c *fiber.Ctx) error {
+	clients, err := models.ListOAuthClients()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list clients",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"clients": clients,
+	})
+}
+
+// DeleteOAuthClient removes an OAuth client
+func DeleteOAuthClient(c *fiber.Ctx) error {
+	deleted, err := models.DeleteOAuthClient(c.Params("client_id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete client",
+		})
+	}
+
+	if !deleted {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Client not found",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Client deleted successfully",
+	})
+}
@@ -0,0 +1,528 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/kafka"
+	"auth-api/internal/metrics"
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+	// Slug defaults to one derived from the name
+	Slug string `json:"slug"`
+}
+
+type SwitchOrganizationRequest struct {
+	OrganizationID string `json:"organization_id"`
+	RefreshToken   string `json:"refresh_token"`
+}
+
+type InviteMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type AcceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+// CreateOrganization creates an organization owned by the current user. It
+// becomes their active organization once they switch to it.
+func CreateOrganization(c *fiber.Ctx) error {
+	var req CreateOrganizationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 255 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required and must be at most 255 characters",
+		})
+	}
+	if req.Slug == "" {
+		req.Slug = slugify(req.Name)
+	}
+	if !utils.IsSlug(req.Slug) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Slug must be lowercase letters, digits and dashes",
+		})
+	}
+
+	userID := middleware.GetUserID(c)
+	membership, err := models.CreateOrganization(userID, req.Name, req.Slug)
+	if err != nil {
+		if errors.Is(err, models.ErrOrganizationSlugTaken) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to create organization: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create organization",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "organization.created", fiber.Map{
+		"organization_id": membership.Organization.ID,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":      "Organization created successfully",
+		"organization": membership.Organization,
+		"role":         membership.Role,
+	})
+}
+
+// ListOrganizations lists the organizations the current user belongs to
+func ListOrganizations(c *fiber.Ctx) error {
+	memberships, err := models.ListMemberships(middleware.GetUserID(c))
+	if err != nil {
+		log.Printf("Failed to list organizations: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list organizations",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"organizations":          memberships,
+		"active_organization_id": middleware.GetOrganizationID(c),
+	})
+}
+
+// SwitchOrganization moves the current session to another of the user's
+// organizations. The session's refresh token is rotated and a new token
+// pair carrying the organization is issued; the session's earlier access
+// tokens are revoked.
+func SwitchOrganization(c *fiber.Ctx) error {
+	var req SwitchOrganizationRequest
+	if err := c.BodyParser(&req); err != nil || req.OrganizationID == "" || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Organization ID and refresh token are required",
+		})
+	}
+
+	userID := middleware.GetUserID(c)
+	if !utils.IsUUID(req.OrganizationID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Organization not found",
+		})
+	}
+	membership, err := models.GetMembership(req.OrganizationID, userID)
+	if err != nil {
+		if models.IsNotFound(err) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Organization not found",
+			})
+		}
+		log.Printf("Failed to load membership: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to switch organization",
+		})
+	}
+
+	user, storedToken, ok, err := redeemRefreshToken(c, req.RefreshToken, userID)
+	if !ok {
+		return err
+	}
+
+	if err := models.RevokeAccessTokensForSession(storedToken.FamilyID); err != nil {
+		log.Printf("Failed to revoke access tokens of session %s: %v", storedToken.FamilyID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to switch organization",
+		})
+	}
+
+	storedToken.OrganizationID = &membership.Organization.ID
+	tokens, message, err := issueTokenPair(c, user, storedToken, "")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       "Organization switched",
+		"organization":  membership.Organization,
+		"role":          membership.Role,
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    900, // 15 minutes in seconds
+	})
+}
+
+// AcceptOrganizationInvitation adds the current user to the organization an
+// invitation was sent for. The invitation must be addressed to their email.
+func AcceptOrganizationInvitation(c *fiber.Ctx) error {
+	var req AcceptInvitationRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invitation token is required",
+		})
+	}
+
+	userID := middleware.GetUserID(c)
+	membership, err := models.AcceptOrganizationInvitation(utils.HashToken(req.Token), userID, middleware.GetUserEmail(c))
+	if err != nil {
+		if models.IsNotFound(err) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Invalid or expired invitation",
+			})
+		}
+		if errors.Is(err, models.ErrAlreadyMember) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to accept invitation: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to accept invitation",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "organization.joined", fiber.Map{
+		"organization_id": membership.Organization.ID,
+		"role":            membership.Role,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":      "Invitation accepted",
+		"organization": membership.Organization,
+		"role":         membership.Role,
+	})
+}
+
+// GetOrganization returns the caller's active organization and their role in it
+func GetOrganization(c *fiber.Ctx) error {
+	membership, err := models.GetMembership(middleware.GetOrganizationID(c), middleware.GetUserID(c))
+	if err != nil {
+		if models.IsNotFound(err) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Organization not found",
+			})
+		}
+		log.Printf("Failed to load organization: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load organization",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"organization": membership.Organization,
+		"role":         membership.Role,
+	})
+}
+
+// ListOrganizationMembers lists the members of the caller's organization
+func ListOrganizationMembers(c *fiber.Ctx) error {
+	members, err := models.ListOrganizationMembers(middleware.GetOrganizationID(c))
+	if err != nil {
+		log.Printf("Failed to list organization members: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list members",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"members": members,
+	})
+}
+
+// UpdateOrganizationMember changes a member's role. Callers can only manage
+// members and hand out roles up to their own.
+func UpdateOrganizationMember(c *fiber.Ctx) error {
+	var req UpdateMemberRequest
+	if err := c.BodyParser(&req); err != nil || models.OrgRoleRank(req.Role) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role must be owner, admin or member",
+		})
+	}
+
+	organizationID, memberID := middleware.GetOrganizationID(c), c.Params("user_id")
+	member, ok, err := manageableMember(c, organizationID, memberID)
+	if !ok {
+		return err
+	}
+	if models.OrgRoleRank(req.Role) > models.OrgRoleRank(middleware.GetOrgRole(c)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot grant a role above your own",
+		})
+	}
+
+	if _, err := models.UpdateMemberRole(organizationID, memberID, req.Role); err != nil {
+		if errors.Is(err, models.ErrLastOwner) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to update organization member: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update member",
+		})
+	}
+
+	// Their access tokens carry the old role
+	revokeAccessTokensOf([]string{memberID})
+	recordSecurityEvent(c, memberID, "organization.member_role_changed", fiber.Map{
+		"organization_id": organizationID,
+		"changed_by":      middleware.GetUserID(c),
+		"old_role":        member.Role,
+		"new_role":        req.Role,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Member updated",
+	})
+}
+
+// RemoveOrganizationMember removes a member from the caller's organization.
+// Any member may remove themselves to leave it.
+func RemoveOrganizationMember(c *fiber.Ctx) error {
+	organizationID, memberID := middleware.GetOrganizationID(c), c.Params("user_id")
+	userID := middleware.GetUserID(c)
+
+	if memberID != userID {
+		if models.OrgRoleRank(middleware.GetOrgRole(c)) < models.OrgRoleRank(models.OrgRoleAdmin) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient organization role",
+			})
+		}
+		if _, ok, err := manageableMember(c, organizationID, memberID); !ok {
+			return err
+		}
+	}
+
+	removed, err := models.RemoveMember(organizationID, memberID)
+	if err != nil {
+		if errors.Is(err, models.ErrLastOwner) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to remove organization member: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to remove member",
+		})
+	}
+	if !removed {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Member not found",
+		})
+	}
+
+	// Their access tokens still name the organization
+	revokeAccessTokensOf([]string{memberID})
+	recordSecurityEvent(c, memberID, "organization.member_removed", fiber.Map{
+		"organization_id": organizationID,
+		"removed_by":      userID,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Member removed",
+	})
+}
+
+// manageableMember loads a member of the organization that the caller may
+// manage, i.e. one whose role is not above the caller's
+func manageableMember(c *fiber.Ctx, organizationID, memberID string) (*models.Membership, bool, error) {
+	if !utils.IsUUID(memberID) {
+		return nil, false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Member not found",
+		})
+	}
+
+	member, err := models.GetMembership(organizationID, memberID)
+	if err != nil {
+		if models.IsNotFound(err) {
+			return nil, false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Member not found",
+			})
+		}
+		log.Printf("Failed to load organization member: %v", err)
+		return nil, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load member",
+		})
+	}
+
+	if models.OrgRoleRank(member.Role) > models.OrgRoleRank(middleware.GetOrgRole(c)) {
+		return nil, false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot manage a member with a role above your own",
+		})
+	}
+	return member, true, nil
+}
+
+// InviteOrganizationMember emails an invitation to join the caller's
+// organization. It replaces any pending invitation of the same address.
+func InviteOrganizationMember(c *fiber.Ctx) error {
+	var req InviteMemberRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email is required",
+		})
+	}
+	if req.Role == "" {
+		req.Role = models.OrgRoleMember
+	}
+	if models.OrgRoleRank(req.Role) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role must be owner, admin or member",
+		})
+	}
+	if models.OrgRoleRank(req.Role) > models.OrgRoleRank(middleware.GetOrgRole(c)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot grant a role above your own",
+		})
+	}
+
+	membership, err := models.GetMembership(middleware.GetOrganizationID(c), middleware.GetUserID(c))
+	if err != nil {
+		log.Printf("Failed to load organization: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send invitation",
+		})
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send invitation",
+		})
+	}
+
+	inviterID := middleware.GetUserID(c)
+	invitation := &models.OrganizationInvitation{
+		OrganizationID: membership.Organization.ID,
+		Email:          req.Email,
+		Role:           req.Role,
+		InvitedBy:      &inviterID,
+		ExpiresAt:      time.Now().Add(config.GetEnvDuration("ORG_INVITATION_TTL", 7*24*time.Hour)),
+	}
+	if err := models.CreateOrganizationInvitation(invitation, utils.HashToken(token)); err != nil {
+		log.Printf("Failed to store invitation: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send invitation",
+		})
+	}
+
+	if err := kafka.SendOrganizationInvitationEmail(req.Email, membership.Organization.Name,
+		organizationInvitationURL(token), invitation.ExpiresAt); err != nil {
+		metrics.RecordEmailSent("organization_invitation", false)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send invitation",
+		})
+	}
+	metrics.RecordEmailSent("organization_invitation", true)
+
+	recordSecurityEvent(c, inviterID, "organization.member_invited", fiber.Map{
+		"organization_id": invitation.OrganizationID,
+		"invitation_id":   invitation.ID,
+		"role":            invitation.Role,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":    "Invitation sent",
+		"invitation": invitation,
+	})
+}
+
+// ListOrganizationInvitations lists the pending invitations of the caller's
+// organization
+func ListOrganizationInvitations(c *fiber.Ctx) error {
+	invitations, err := models.ListOrganizationInvitations(middleware.GetOrganizationID(c))
+	if err != nil {
+		log.Printf("Failed to list invitations: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list invitations",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"invitations": invitations,
+	})
+}
+
+// DeleteOrganizationInvitation withdraws a pending invitation
+func DeleteOrganizationInvitation(c *fiber.Ctx) error {
+	invitationID := c.Params("id")
+	if !utils.IsUUID(invitationID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Invitation not found",
+		})
+	}
+
+	deleted, err := models.DeleteOrganizationInvitation(middleware.GetOrganizationID(c), invitationID)
+	if err != nil {
+		log.Printf("Failed to delete invitation: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete invitation",
+		})
+	}
+	if !deleted {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Invitation not found",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Invitation deleted",
+	})
+}
+
+// organizationInvitationURL builds the link to accept an invitation
+// (ORG_INVITATION_URL)
+func organizationInvitationURL(token string) string {
+	query := url.Values{}
+	query.Set("token", token)
+	return config.GetEnv("ORG_INVITATION_URL", "http://localhost:3000/organizations/join") + "?" + query.Encode()
+}
+
+// slugify derives a slug from an organization name, e.g. "Acme Corp." becomes
+// "acme-corp"
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > 100 {
+		slug = strings.TrimRight(slug[:100], "-")
+	}
+	return slug
+}
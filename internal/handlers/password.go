@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/kafka"
+	"auth-api/internal/metrics"
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePassword changes the logged-in user's password after checking the
+// current one. Every other session is signed out; the current one stays.
+func ChangePassword(c *fiber.Ctx) error {
+	var req ChangePasswordRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Current and new password are required",
+		})
+	}
+
+	user, err := models.GetUserByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if ok, err := verifyCurrentPassword(c, user, req.CurrentPassword); !ok {
+		return err
+	}
+
+	if ok, err := checkNewPassword(c, req.NewPassword, user.Email, user.DisplayName, user.GivenName, user.FamilyName); !ok {
+		return err
+	}
+	if ok, err := checkPasswordReuse(c, user, req.NewPassword); !ok {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to hash password",
+		})
+	}
+
+	if err := models.ChangeUserPassword(user.ID, hashedPassword, passwordHistorySize()); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update password",
+		})
+	}
+
+	sessionID := middleware.GetSessionID(c)
+	if err := models.RevokeOtherSessions(user.ID, sessionID); err != nil {
+		log.Printf("Failed to revoke other sessions: %v", err)
+	}
+
+	recordSecurityEvent(c, user.ID, "password.changed", fiber.Map{
+		"kept_session_id": sessionID,
+	})
+
+	if err := kafka.SendPasswordChangedEmail(user.Email); err != nil {
+		log.Printf("Failed to send password changed email: %v", err)
+		metrics.RecordEmailSent("password_changed", false)
+	} else {
+		metrics.RecordEmailSent("password_changed", true)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Password changed successfully. Your other sessions have been signed out.",
+	})
+}
+
+// verifyCurrentPassword re-authenticates a logged-in user before a sensitive
+// change. Wrong passwords count as failed logins, so a stolen access token
+// cannot be used to guess the password. When the check fails the response is
+// already written and ok is false.
+func verifyCurrentPassword(c *fiber.Ctx, user *models.User, password string) (bool, error) {
+	retryAfter, scope, err := claimLoginAttempt(c, user)
+	if err != nil {
+		log.Printf("Failed to check login throttling: %v", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify password",
+		})
+	}
+	if retryAfter > 0 {
+		metrics.RecordAuthLoginThrottled(scope)
+		return false, tooManyLoginAttempts(c, retryAfter, "Too many failed attempts, try again later")
+	}
+
+	if !utils.CheckPasswordHash(password, user.PasswordHash) {
+		recordClaimedLoginFailure(c, user)
+		return false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Current password is incorrect",
+		})
+	}
+
+	if user.FailedLoginAttempts > 0 {
+		if err := models.ResetUserLoginFailures(user.ID); err != nil {
+			log.Printf("Failed to reset failed login counter: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// checkNewPassword applies the password policy to a new password. When the
+// password is rejected the response, listing every broken rule and the
+// strength feedback, is already written and ok is false.
+func checkNewPassword(c *fiber.Ctx, password, email string, userInputs ...string) (bool, error) {
+	err := utils.ValidatePassword(password, email, userInputs...)
+	if err == nil {
+		return true, nil
+	}
+
+	var policyErr *utils.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":      "Password does not meet the requirements",
+			"violations": policyErr.Violations,
+			"strength":   policyErr.Strength,
+		})
+	}
+	log.Printf("Failed to check password policy: %v", err)
+	return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to check password",
+	})
+}
+
+// checkPasswordReuse rejects the user's current password and the last
+// PASSWORD_HISTORY_SIZE ones. When the password is rejected the response is
+// already written and ok is false.
+func checkPasswordReuse(c *fiber.Ctx, user *models.User, password string) (bool, error) {
+	size := passwordHistorySize()
+	if size == 0 {
+		return true, nil
+	}
+
+	history, err := models.ListPasswordHistory(user.ID, size)
+	if err != nil {
+		log.Printf("Failed to load password history: %v", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check password",
+		})
+	}
+
+	for _, hash := range append([]string{user.PasswordHash}, history...) {
+		if utils.CheckPasswordHash(password, hash) {
+			return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Password does not meet the requirements",
+				"violations": []utils.PasswordViolation{{
+					Rule:    "history",
+					Message: fmt.Sprintf("Password must differ from your last %d passwords", size+1),
+				}},
+			})
+		}
+	}
+	return true, nil
+}
+
+// passwordHistorySize is how many previous passwords are kept to stop reuse
+func passwordHistorySize() int {
+	return config.GetEnvInt("PASSWORD_HISTORY_SIZE", 5)
+}
+
+type PasswordStrengthRequest struct {
+	Password string `json:"password"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+}
+
+// PasswordStrength estimates how guessable a password is, with a score from 0
+// to 4 and suggestions for weak ones, so forms can give feedback while the
+// user types. The email and name make passwords based on them score lower.
+func PasswordStrength(c *fiber.Ctx) error {
+	var req PasswordStrengthRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	return c.JSON(utils.EstimatePasswordStrength(req.Password, req.Email, req.Name))
+}
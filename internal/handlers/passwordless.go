@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/kafka"
+	"auth-api/internal/metrics"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// passwordlessBindingCookie holds the nonce that ties a magic link or login
+// code to the browser that asked for it
+const passwordlessBindingCookie = "passwordless_binding"
+
+type PasswordlessStartRequest struct {
+	Email  string `json:"email"`
+	Method string `json:"method"` // "link" or "code"
+}
+
+type PasswordlessCompleteRequest struct {
+	Email      string `json:"email"`
+	Token      string `json:"token"`
+	Code       string `json:"code"`
+	Binding    string `json:"binding"`
+	DeviceName string `json:"device_name"`
+}
+
+// PasswordlessStart emails a single-use magic link or login code. The caller
+// gets a binding nonce, as a cookie and in the body for non-browser clients,
+// which has to be presented again to complete the login. The answer is the
+// same for unknown emails.
+func PasswordlessStart(c *fiber.Ctx) error {
+	var req PasswordlessStartRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	if req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email is required",
+		})
+	}
+
+	if req.Method == "" {
+		req.Method = "link"
+	}
+	purpose := models.PurposeMagicLink
+	if req.Method == "code" {
+		purpose = models.PurposeLogin
+	} else if req.Method != "link" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Method must be link or code",
+		})
+	}
+
+	binding, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start passwordless login",
+		})
+	}
+
+	ttl := config.GetEnvDuration("PASSWORDLESS_BINDING_TTL", 15*time.Minute)
+	c.Cookie(&fiber.Cookie{
+		Name:     passwordlessBindingCookie,
+		Value:    binding,
+		Path:     "/auth/passwordless",
+		Expires:  time.Now().Add(ttl),
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	response := fiber.Map{
+		"message": "If the email belongs to an account, a login " + req.Method + " has been sent",
+		"binding": binding,
+	}
+
+	user, err := models.GetUserByEmail(req.Email)
+	if err != nil {
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+
+	secret, err := models.IssueCode(purpose, user.Email, map[string]string{
+		"binding": utils.HashToken(binding),
+	})
+	if errors.Is(err, models.ErrCodeRecentlyIssued) || errors.Is(err, models.ErrCodeDailyLimit) {
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+	if err != nil {
+		log.Printf("Failed to issue passwordless login %s: %v", req.Method, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start passwordless login",
+		})
+	}
+
+	if req.Method == "code" {
+		err = kafka.SendLoginCodeEmail(user.Email, secret)
+	} else {
+		err = kafka.SendMagicLinkEmail(user.Email, magicLinkURL(user.Email, secret))
+	}
+	if err != nil {
+		metrics.RecordEmailSent("passwordless_"+req.Method, false)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send login email",
+		})
+	}
+
+	metrics.RecordEmailSent("passwordless_"+req.Method, true)
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// PasswordlessComplete exchanges a magic link token or login code for the
+// same response Login gives. The code is consumed even when the binding does
+// not match, so a leaked link cannot be retried from another browser.
+func PasswordlessComplete(c *fiber.Ctx) error {
+	var req PasswordlessCompleteRequest
+
+	if err := c.BodyParser(&req); err != nil {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	purpose, secret := models.PurposeMagicLink, req.Token
+	if req.Code != "" {
+		purpose, secret = models.PurposeLogin, req.Code
+	}
+
+	if req.Email == "" || secret == "" {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Email and token or code are required",
+		})
+	}
+
+	user, err := models.GetUserByEmail(req.Email)
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": models.ErrCodeNotFound.Error(),
+		})
+	}
+
+	// Locked accounts stay locked for passwordless logins too
+	retryAfter, scope, err := loginRetryAfter(c, user)
+	if err != nil {
+		log.Printf("Failed to check login throttling: %v", err)
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to process login",
+		})
+	}
+	if retryAfter > 0 {
+		metrics.RecordAuthLogin(false)
+		metrics.RecordAuthLoginThrottled(scope)
+		return tooManyLoginAttempts(c, retryAfter, "Too many failed login attempts, try again later")
+	}
+
+	data, err := models.VerifyCode(purpose, user.Email, secret)
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		// Wrong codes count towards the account lock like wrong passwords,
+		// otherwise requesting fresh codes would allow unlimited guesses
+		if errors.Is(err, models.ErrCodeInvalid) || errors.Is(err, models.ErrCodeTooManyAttempts) {
+			if recordLoginFailure(c, user) {
+				return tooManyLoginAttempts(c, config.GetEnvDuration("LOGIN_LOCK_DURATION", 15*time.Minute),
+					"Account locked after too many failed login attempts")
+			}
+		}
+		return otpError(c, err)
+	}
+
+	binding := c.Cookies(passwordlessBindingCookie)
+	if binding == "" {
+		binding = req.Binding
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(binding)), []byte(data["binding"])) != 1 {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "This login was requested from a different browser, please request a new one",
+		})
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     passwordlessBindingCookie,
+		Path:     "/auth/passwordless",
+		Expires:  time.Now().Add(-time.Hour),
+		HTTPOnly: true,
+	})
+
+	// Receiving the email proves the address belongs to the user
+	if !user.IsVerified {
+		if err := models.MarkUserVerified(user.ID); err != nil {
+			log.Printf("Failed to mark user verified: %v", err)
+		} else {
+			user.IsVerified = true
+		}
+	}
+
+	if user.FailedLoginAttempts > 0 {
+		if err := models.ResetUserLoginFailures(user.ID); err != nil {
+			log.Printf("Failed to reset failed login counter: %v", err)
+		}
+	}
+
+	return completeLogin(c, user, req.DeviceName)
+}
+
+// magicLinkURL builds the link emailed for a magic link login. The page at
+// PASSWORDLESS_LINK_URL is expected to post email and token to
+// /auth/passwordless/complete.
+func magicLinkURL(email, token string) string {
+	query := url.Values{}
+	query.Set("email", email)
+	query.Set("token", token)
+	return config.GetEnv("PASSWORDLESS_LINK_URL", "http://localhost:3000/login/magic") + "?" + query.Encode()
+}
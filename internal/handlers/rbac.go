@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateRoleRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type CreatePermissionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ListRoles lists every role with the permissions it grants
+func ListRoles(c *fiber.Ctx) error {
+	roles, err := models.ListRoles()
+	if err != nil {
+		log.Printf("Failed to list roles: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list roles",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"roles": roles,
+	})
+}
+
+// CreateRole adds a role; permissions are granted to it separately
+func CreateRole(c *fiber.Ctx) error {
+	var req CreateRoleRequest
+	if err := c.BodyParser(&req); err != nil || !utils.IsRoleName(req.Name) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name must be lowercase letters, digits and underscores",
+		})
+	}
+
+	role, err := models.CreateRole(req.Name, req.Description)
+	if err != nil {
+		if errors.Is(err, models.ErrRoleExists) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to create role: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create role",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Role created successfully",
+		"role":    role,
+	})
+}
+
+// DeleteRole removes a role from the system and from every user that has it.
+// Admins can only delete roles they have themselves.
+func DeleteRole(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if !middleware.HasRole(c, name) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot delete a role above your own",
+		})
+	}
+
+	userIDs, err := models.ListUserIDsWithRole(name)
+	if err != nil {
+		log.Printf("Failed to list users with role: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete role",
+		})
+	}
+
+	deleted, err := models.DeleteRole(name)
+	if err != nil {
+		if errors.Is(err, models.ErrBuiltInRole) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to delete role: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete role",
+		})
+	}
+	if !deleted {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+
+	revokeAccessTokensOf(userIDs)
+	recordSecurityEvent(c, "", "role.deleted", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+		"role":     name,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Role deleted successfully",
+	})
+}
+
+// ListPermissions lists every permission that can be granted
+func ListPermissions(c *fiber.Ctx) error {
+	permissions, err := models.ListPermissions()
+	if err != nil {
+		log.Printf("Failed to list permissions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list permissions",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"permissions": permissions,
+	})
+}
+
+// CreatePermission adds a permission for routes to require
+func CreatePermission(c *fiber.Ctx) error {
+	var req CreatePermissionRequest
+	if err := c.BodyParser(&req); err != nil || !utils.IsPermissionName(req.Name) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name must look like resource:action, e.g. users:write",
+		})
+	}
+
+	permission, err := models.CreatePermission(req.Name, req.Description)
+	if err != nil {
+		if errors.Is(err, models.ErrPermissionExists) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		log.Printf("Failed to create permission: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create permission",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":    "Permission created successfully",
+		"permission": permission,
+	})
+}
+
+// DeletePermission removes a permission and every grant of it
+func DeletePermission(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	userIDs, err := models.ListUserIDsWithPermission(name)
+	if err != nil {
+		log.Printf("Failed to list users with permission: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete permission",
+		})
+	}
+
+	deleted, err := models.DeletePermission(name)
+	if err != nil {
+		log.Printf("Failed to delete permission: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete permission",
+		})
+	}
+	if !deleted {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Permission not found",
+		})
+	}
+
+	revokeAccessTokensOf(userIDs)
+	recordSecurityEvent(c, "", "permission.deleted", fiber.Map{
+		"admin_id":   middleware.GetUserID(c),
+		"permission": name,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Permission deleted successfully",
+	})
+}
+
+// GrantPermission grants a permission to a role. Holders of the role get it
+// in their next access token. Admins can only change roles they have and
+// only grant permissions they have, so they cannot widen their own rights.
+func GrantPermission(c *fiber.Ctx) error {
+	role, permission := c.Params("name"), c.Params("permission")
+	if !middleware.HasRole(c, role) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot change a role above your own",
+		})
+	}
+	allowed, err := middleware.HasPermission(c, permission)
+	if err != nil {
+		log.Printf("Failed to check permissions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to grant permission",
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot grant a permission you do not have",
+		})
+	}
+
+	found, err := models.GrantPermission(role, permission)
+	if err != nil {
+		log.Printf("Failed to grant permission: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to grant permission",
+		})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Role or permission not found",
+		})
+	}
+
+	recordSecurityEvent(c, "", "permission.granted", fiber.Map{
+		"admin_id":   middleware.GetUserID(c),
+		"role":       role,
+		"permission": permission,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Permission granted",
+	})
+}
+
+// RevokePermission takes a permission away from a role. Access tokens of the
+// role's holders are revoked so the permission cannot outlive the change.
+// Admins can only change roles they have themselves.
+func RevokePermission(c *fiber.Ctx) error {
+	role, permission := c.Params("name"), c.Params("permission")
+	if !middleware.HasRole(c, role) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot change a role above your own",
+		})
+	}
+
+	revoked, err := models.RevokePermission(role, permission)
+	if err != nil {
+		log.Printf("Failed to revoke permission: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke permission",
+		})
+	}
+	if !revoked {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Role does not have this permission",
+		})
+	}
+
+	if userIDs, err := models.ListUserIDsWithRole(role); err != nil {
+		log.Printf("Failed to list users with role: %v", err)
+	} else {
+		revokeAccessTokensOf(userIDs)
+	}
+	recordSecurityEvent(c, "", "permission.revoked", fiber.Map{
+		"admin_id":   middleware.GetUserID(c),
+		"role":       role,
+		"permission": permission,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Permission revoked",
+	})
+}
+
+// ListUserRoles returns the roles of any user
+func ListUserRoles(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	roles, err := models.GetUserRoles(user.ID)
+	if err != nil {
+		log.Printf("Failed to list user roles: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list roles",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"user_id":         user.ID,
+		"primary_role":    user.Role,
+		"roles":           roles,
+		"effective_roles": models.EffectiveRoles(roles),
+	})
+}
+
+// AssignUserRole gives a user a role, effective from their next access token.
+// Admins can only hand out roles they have themselves, directly or through
+// the hierarchy, and only to users they outrank.
+func AssignUserRole(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+	role := c.Params("role")
+	if !middleware.HasRole(c, role) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot assign a role above your own",
+		})
+	}
+
+	found, err := models.AssignRole(user.ID, role)
+	if err != nil {
+		log.Printf("Failed to assign role: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to assign role",
+		})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+
+	recordSecurityEvent(c, user.ID, "role.assigned", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+		"role":     role,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Role assigned",
+	})
+}
+
+// RemoveUserRole takes a role away from a user and revokes their access
+// tokens, which carry the old roles
+func RemoveUserRole(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+	role := c.Params("role")
+	if !middleware.HasRole(c, role) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Cannot remove a role above your own",
+		})
+	}
+
+	removed, err := models.RemoveRole(user.ID, role)
+	if err != nil {
+		log.Printf("Failed to remove role: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to remove role",
+		})
+	}
+	if !removed {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User does not have this role",
+		})
+	}
+
+	revokeAccessTokensOf([]string{user.ID})
+	recordSecurityEvent(c, user.ID, "role.removed", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+		"role":     role,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Role removed",
+	})
+}
+
+// revokeAccessTokensOf revokes the access tokens of users whose roles or
+// permissions shrank. Their sessions stay; the next refresh picks up the
+// new grants.
+func revokeAccessTokensOf(userIDs []string) {
+	for _, userID := range userIDs {
+		if err := models.RevokeAllAccessTokensForUser(userID); err != nil {
+			log.Printf("Failed to revoke access tokens of %s: %v", userID, err)
+		}
+	}
+}
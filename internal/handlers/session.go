@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListSessions returns the current user's active sessions
+func ListSessions(c *fiber.Ctx) error {
+	return listSessions(c, middleware.GetUserID(c), middleware.GetSessionID(c))
+}
+
+// RevokeSession logs one of the current user's sessions out
+func RevokeSession(c *fiber.Ctx) error {
+	return revokeSession(c, middleware.GetUserID(c), c.Params("session_id"))
+}
+
+// RevokeOtherSessions logs the current user out everywhere except this device
+func RevokeOtherSessions(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+	sessionID := middleware.GetSessionID(c)
+
+	if err := models.RevokeOtherSessions(userID, sessionID); err != nil {
+		log.Printf("Failed to revoke sessions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke sessions",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "session.revoked_others", fiber.Map{
+		"kept_session_id": sessionID,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "All other sessions revoked",
+	})
+}
+
+// AdminListUserSessions returns the active sessions of any user
+func AdminListUserSessions(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	recordSecurityEvent(c, user.ID, "admin.user_sessions_viewed", fiber.Map{
+		"admin_id": middleware.GetUserID(c),
+	})
+	return listSessions(c, user.ID, "")
+}
+
+// AdminRevokeUserSession logs one session of any user out
+func AdminRevokeUserSession(c *fiber.Ctx) error {
+	user, err := models.GetUserByID(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if ok, err := manageableUser(c, user); !ok {
+		return err
+	}
+	return revokeSession(c, user.ID, c.Params("session_id"))
+}
+
+func listSessions(c *fiber.Ctx, userID, currentSessionID string) error {
+	sessions, err := models.ListSessionsForUser(userID)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list sessions",
+		})
+	}
+
+	result := make([]fiber.Map, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, fiber.Map{
+			"id":           session.ID,
+			"device_name":  session.DeviceName,
+			"user_agent":   session.UserAgent,
+			"ip_address":   session.IPAddress,
+			"created_at":   session.CreatedAt,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      session.ID == currentSessionID,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"sessions": result,
+	})
+}
+
+func revokeSession(c *fiber.Ctx, userID, sessionID string) error {
+	if !utils.IsUUID(sessionID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Session not found",
+		})
+	}
+
+	revoked, err := models.RevokeSession(userID, sessionID)
+	if err != nil {
+		log.Printf("Failed to revoke session: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	if !revoked {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Session not found",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "session.revoked", fiber.Map{
+		"session_id": sessionID,
+		"revoked_by": middleware.GetUserID(c),
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Session revoked",
+	})
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/metrics"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TokenPair is the access/refresh token pair returned to clients
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	SessionID    string
+}
+
+// issueTokenPair generates and stores a new access and refresh token for a
+// user. Without a parent it starts a new session (refresh token family)
+// named deviceName; with one, the new refresh token joins the parent's family
+// and keeps its device name and organization. The request's user agent and IP
+// are recorded.
+func issueTokenPair(c *fiber.Ctx, user *models.User, parent *models.RefreshToken, deviceName string) (*TokenPair, string, error) {
+	refreshToken, err := utils.GenerateRefreshToken(user.ID, user.Email)
+	if err != nil {
+		return nil, "Failed to generate refresh token", err
+	}
+
+	// Hash and store refresh token
+	stored := &models.RefreshToken{
+		UserID:     user.ID,
+		TokenHash:  utils.HashRefreshToken(refreshToken),
+		ExpiresAt:  time.Now().Add(utils.RefreshTokenTTL),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		IPAddress:  c.IP(),
+		DeviceName: deviceName,
+	}
+	if parent != nil {
+		stored.FamilyID = parent.FamilyID
+		stored.ParentID = &parent.ID
+		stored.DeviceName = parent.DeviceName
+	}
+
+	// Keep working in the session's organization while the user is still a
+	// member; otherwise fall back to their oldest membership
+	var organizationID *string
+	if parent != nil {
+		organizationID = parent.OrganizationID
+	}
+	membership, err := models.GetActiveMembership(user.ID, organizationID)
+	if err != nil {
+		return nil, "Failed to load organization", err
+	}
+	if membership != nil {
+		stored.OrganizationID = &membership.Organization.ID
+	}
+
+	if err := models.StoreRefreshToken(stored); err != nil {
+		return nil, "Failed to store refresh token", err
+	}
+
+	roles, err := models.GetUserRoles(user.ID)
+	if err != nil {
+		return nil, "Failed to load roles", err
+	}
+	var permissions []string
+	if config.GetEnvBool("RBAC_EMBED_PERMISSIONS", true) {
+		if permissions, err = models.LoadUserPermissions(user.ID); err != nil {
+			return nil, "Failed to load permissions", err
+		}
+	}
+
+	claims := utils.Claims{
+		UserID:      user.ID,
+		Email:       user.Email,
+		Role:        user.Role,
+		Roles:       roles,
+		Permissions: permissions,
+		SessionID:   stored.FamilyID,
+	}
+	if membership != nil {
+		claims.OrganizationID = membership.Organization.ID
+		claims.OrgRole = membership.Role
+	}
+
+	accessToken, err := utils.GenerateAccessToken(claims)
+	if err != nil {
+		return nil, "Failed to generate access token", err
+	}
+
+	// Store the access token hash so it can be revoked before it expires
+	accessExpiresAt := time.Now().Add(utils.AccessTokenTTL)
+	if err := models.StoreAccessToken(user.ID, stored.FamilyID, utils.HashToken(accessToken), accessExpiresAt); err != nil {
+		return nil, "Failed to store access token", err
+	}
+
+	// Record JWT token generation metrics
+	metrics.RecordJWTTokenGenerated("access_token")
+	metrics.RecordJWTTokenGenerated("refresh_token")
+
+	return &TokenPair{AccessToken: accessToken, RefreshToken: refreshToken, SessionID: stored.FamilyID}, "", nil
+}
+
+// completeLogin finishes a login once the first factor has been checked. Users
+// with a second factor get an MFA challenge, everyone else gets tokens.
+func completeLogin(c *fiber.Ctx, user *models.User, deviceName string) error {
+	if ok, err := accountActive(c, user); !ok {
+		metrics.RecordAuthLogin(false)
+		return err
+	}
+
+	methods, err := secondFactors(user.ID)
+	if err != nil {
+		log.Printf("Failed to check MFA status: %v", err)
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check two-factor authentication",
+		})
+	}
+
+	if len(methods) > 0 {
+		return startMFAChallenge(c, user, deviceName, methods)
+	}
+
+	return respondWithTokens(c, user, deviceName)
+}
+
+// respondWithTokens starts a new session and returns the login response
+func respondWithTokens(c *fiber.Ctx, user *models.User, deviceName string) error {
+	if ok, err := accountActive(c, user); !ok {
+		metrics.RecordAuthLogin(false)
+		return err
+	}
+
+	tokens, message, err := issueTokenPair(c, user, nil, deviceName)
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+
+	metrics.RecordAuthLogin(true)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":       "Login successful",
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    900, // 15 minutes in seconds
+		"user": fiber.Map{
+			"id":          user.ID,
+			"email":       user.Email,
+			"is_verified": user.IsVerified,
+			"role":        user.Role,
+			"created_at":  user.CreatedAt,
+		},
+	})
+}
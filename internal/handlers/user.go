@@ -3,48 +3,158 @@ package handlers
 import (
 	"auth-api/internal/middleware"
 	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// GetProfile returns the current user's profile
+// GetProfile returns the current user's profile with an ETag for
+// conditional updates
 func GetProfile(c *fiber.Ctx) error {
-	userEmail := middleware.GetUserEmail(c)
-
-	// Get user from database
-	user, err := models.GetUserByEmail(userEmail)
+	// Look the user up by ID; the email claim may be older than an email change
+	user, err := models.GetUserByID(middleware.GetUserID(c))
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "User not found",
 		})
 	}
 
+	c.Set(fiber.HeaderETag, profileETag(user))
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Profile retrieved successfully",
-		"user": fiber.Map{
-			"id":           user.ID,
-			"email":        user.Email,
-			"is_verified":  user.IsVerified,
-			"role":         user.Role,
-			"created_at":   user.CreatedAt,
-		},
+		"user":    profileResponse(user),
 	})
 }
 
-// UpdateProfile updates the current user's profile
+// UpdateProfile applies a partial update to the current user's profile.
+// Fields left out of the body are unchanged. With an If-Match header the
+// update is refused with 412 when the profile changed since it was read.
 func UpdateProfile(c *fiber.Ctx) error {
-	userID := middleware.GetUserID(c)
-	userEmail := middleware.GetUserEmail(c)
+	var req models.ProfileUpdate
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request",
+		})
+	}
+
+	if errs := validateProfileUpdate(&req); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid profile",
+			"details": errs,
+		})
+	}
+
+	var ifUpdatedAt *time.Time
+	if ifMatch := c.Get(fiber.HeaderIfMatch); ifMatch != "" && ifMatch != "*" {
+		updatedAt, ok := parseProfileETag(ifMatch)
+		if !ok {
+			return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+				"error": "Profile has been modified, fetch it again before updating",
+			})
+		}
+		ifUpdatedAt = &updatedAt
+	}
+
+	user, err := models.UpdateUserProfile(middleware.GetUserID(c), req, ifUpdatedAt)
+	if err != nil {
+		if errors.Is(err, models.ErrProfileModified) {
+			return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+				"error": "Profile has been modified, fetch it again before updating",
+			})
+		}
+		if models.IsNotFound(err) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		log.Printf("Failed to update profile: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update profile",
+		})
+	}
 
-	// For now, just return a success message
-	// You can add actual profile update logic here
+	c.Set(fiber.HeaderETag, profileETag(user))
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Profile updated successfully",
-		"user_id": userID,
-		"email":   userEmail,
+		"user":    profileResponse(user),
 	})
 }
 
+func profileResponse(user *models.User) fiber.Map {
+	return fiber.Map{
+		"id":           user.ID,
+		"email":        user.Email,
+		"is_verified":  user.IsVerified,
+		"role":         user.Role,
+		"display_name": user.DisplayName,
+		"given_name":   user.GivenName,
+		"family_name":  user.FamilyName,
+		"avatar_url":   user.AvatarURL,
+		"locale":       user.Locale,
+		"timezone":     user.Timezone,
+		"created_at":   user.CreatedAt,
+		"updated_at":   user.UpdatedAt,
+	}
+}
+
+// profileETag versions the profile by its updated_at timestamp
+func profileETag(user *models.User) string {
+	return `"` + strconv.FormatInt(user.UpdatedAt.UnixMicro(), 10) + `"`
+}
+
+func parseProfileETag(etag string) (time.Time, bool) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	micros, err := strconv.ParseInt(strings.Trim(etag, `"`), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(micros).UTC(), true
+}
+
+// validateProfileUpdate checks the fields present in a profile update and
+// returns an error message per invalid field
+func validateProfileUpdate(req *models.ProfileUpdate) map[string]string {
+	errs := map[string]string{}
+
+	names := map[string]*string{
+		"display_name": req.DisplayName,
+		"given_name":   req.GivenName,
+		"family_name":  req.FamilyName,
+	}
+	for field, value := range names {
+		if value == nil {
+			continue
+		}
+		*value = strings.TrimSpace(*value)
+		if utf8.RuneCountInString(*value) > 100 {
+			errs[field] = "must be at most 100 characters"
+		}
+	}
+
+	if req.AvatarURL != nil && *req.AvatarURL != "" {
+		if len(*req.AvatarURL) > 2048 || !utils.IsHTTPURL(*req.AvatarURL) {
+			errs["avatar_url"] = "must be an http or https URL of at most 2048 characters"
+		}
+	}
+
+	if req.Locale != nil && *req.Locale != "" && (len(*req.Locale) > 35 || !utils.IsLocale(*req.Locale)) {
+		errs["locale"] = "must be a language tag such as en or pt-BR"
+	}
+
+	if req.Timezone != nil && *req.Timezone != "" && !utils.IsTimezone(*req.Timezone) {
+		errs["timezone"] = "must be an IANA time zone such as Europe/Berlin"
+	}
+
+	return errs
+}
+
 // AdminOnly is an example of an admin-only endpoint
 func AdminOnly(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
@@ -0,0 +1,624 @@
+package handlers
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/metrics"
+	"auth-api/internal/middleware"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Ceremony names stored with webauthn_sessions
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	ceremonyMFA          = "mfa"
+)
+
+var (
+	webAuthnOnce     sync.Once
+	webAuthnInstance *webauthn.WebAuthn
+	webAuthnErr      error
+)
+
+type WebAuthnLoginBeginRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// WebAuthnReauthRequest carries the password that adding or removing a
+// passkey requires on top of the access token
+type WebAuthnReauthRequest struct {
+	Password string `json:"password"`
+}
+
+type RenameWebAuthnCredentialRequest struct {
+	Name string `json:"name"`
+}
+
+// webAuthnUser adapts a user and their credentials to the webauthn.User interface
+type webAuthnUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte                         { return []byte(u.user.ID) }
+func (u *webAuthnUser) WebAuthnName() string                       { return u.user.Email }
+func (u *webAuthnUser) WebAuthnDisplayName() string                { return u.user.Email }
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// getWebAuthn returns the relying party configured from WEBAUTHN_RP_ID,
+// WEBAUTHN_RP_NAME and WEBAUTHN_RP_ORIGINS (comma separated)
+func getWebAuthn() (*webauthn.WebAuthn, error) {
+	webAuthnOnce.Do(func() {
+		webAuthnInstance, webAuthnErr = webauthn.New(&webauthn.Config{
+			RPID:          config.GetEnv("WEBAUTHN_RP_ID", "localhost"),
+			RPDisplayName: config.GetEnv("WEBAUTHN_RP_NAME", "auth-api"),
+			RPOrigins:     strings.Split(config.GetEnv("WEBAUTHN_RP_ORIGINS", "http://localhost:3000"), ","),
+		})
+	})
+	return webAuthnInstance, webAuthnErr
+}
+
+// loadWebAuthnUser loads a user together with their registered credentials
+func loadWebAuthnUser(userID string) (*webAuthnUser, error) {
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := models.ListWebAuthnCredentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := decodeWebAuthnCredentials(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+// decodeWebAuthnCredentials unpacks stored credentials, which keep the
+// library's webauthn.Credential as JSON
+func decodeWebAuthnCredentials(stored []models.WebAuthnCredential) ([]webauthn.Credential, error) {
+	credentials := make([]webauthn.Credential, 0, len(stored))
+	for _, s := range stored {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(s.Data, &credential); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, nil
+}
+
+// webAuthnRegistrationOptions excludes the user's existing credentials so
+// the same authenticator isn't registered twice, and asks for a
+// discoverable credential so it can be used as a passkey
+func webAuthnRegistrationOptions(user *webAuthnUser) []webauthn.RegistrationOption {
+	return []webauthn.RegistrationOption{
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	}
+}
+
+// webAuthnPasskeyLoginOptions requires user verification, which is what
+// makes a passkey login multi-factor on its own
+func webAuthnPasskeyLoginOptions() []webauthn.LoginOption {
+	return []webauthn.LoginOption{
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	}
+}
+
+// saveWebAuthnSession stores ceremony state and returns its ID for the finish step
+func saveWebAuthnSession(userID *string, ceremony string, data *webauthn.SessionData) (string, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	session := &models.WebAuthnSession{
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Data:      encoded,
+		ExpiresAt: time.Now().Add(config.GetEnvDuration("WEBAUTHN_CEREMONY_TTL", 5*time.Minute)),
+	}
+	if err := models.CreateWebAuthnSession(session); err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+// loadWebAuthnSession consumes the ceremony state referenced by ?session_id=
+func loadWebAuthnSession(c *fiber.Ctx, ceremony string) (*models.WebAuthnSession, *webauthn.SessionData, error) {
+	sessionID := c.Query("session_id")
+	if !utils.IsUUID(sessionID) {
+		return nil, nil, errors.New("invalid session")
+	}
+
+	session, err := models.ConsumeWebAuthnSession(sessionID, ceremony)
+	if err != nil || time.Now().After(session.ExpiresAt) {
+		return nil, nil, errors.New("invalid session")
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &data); err != nil {
+		return nil, nil, err
+	}
+	return session, &data, nil
+}
+
+// BeginWebAuthnRegistration starts registering a passkey for the current
+// user after checking their password. A passkey is a permanent way in, so a
+// stolen access token alone must not be enough to add one; the registration
+// session then stands in for the password in the finish step.
+func BeginWebAuthnRegistration(c *fiber.Ctx) error {
+	var req WebAuthnReauthRequest
+
+	rp, err := getWebAuthn()
+	if err != nil {
+		log.Printf("WebAuthn is misconfigured: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "WebAuthn is not available",
+		})
+	}
+
+	if err := c.BodyParser(&req); err != nil || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Password is required",
+		})
+	}
+
+	user, err := loadWebAuthnUser(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if ok, err := verifyCurrentPassword(c, user.user, req.Password); !ok {
+		return err
+	}
+
+	options, data, err := rp.BeginRegistration(user, webAuthnRegistrationOptions(user)...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start registration",
+		})
+	}
+
+	sessionID, err := saveWebAuthnSession(&user.user.ID, ceremonyRegistration, data)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start registration",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"session_id": sessionID,
+		"options":    options,
+	})
+}
+
+// FinishWebAuthnRegistration verifies the authenticator's attestation and
+// stores the new credential. The body is the PublicKeyCredential from the
+// browser; ?session_id= and an optional ?name= are passed in the query.
+func FinishWebAuthnRegistration(c *fiber.Ctx) error {
+	rp, err := getWebAuthn()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "WebAuthn is not available",
+		})
+	}
+
+	userID := middleware.GetUserID(c)
+	session, data, err := loadWebAuthnSession(c, ceremonyRegistration)
+	if err != nil || session.UserID == nil || *session.UserID != userID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired registration session",
+		})
+	}
+
+	user, err := loadWebAuthnUser(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid credential",
+		})
+	}
+
+	credential, err := rp.CreateCredential(user, *data, parsed)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Credential verification failed",
+		})
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store credential",
+		})
+	}
+
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+
+	stored := &models.WebAuthnCredential{
+		UserID:       userID,
+		CredentialID: credential.ID,
+		Name:         name,
+		Data:         encoded,
+	}
+	if err := models.CreateWebAuthnCredential(stored); err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Credential is already registered",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "webauthn.credential_added", fiber.Map{"credential_id": stored.ID})
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":    "Passkey registered successfully",
+		"credential": stored,
+	})
+}
+
+// BeginWebAuthnLogin starts a passwordless passkey login. The options allow
+// any discoverable credential, so no email is needed and none is revealed.
+func BeginWebAuthnLogin(c *fiber.Ctx) error {
+	rp, err := getWebAuthn()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "WebAuthn is not available",
+		})
+	}
+
+	options, data, err := rp.BeginDiscoverableLogin(webAuthnPasskeyLoginOptions()...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start login",
+		})
+	}
+
+	sessionID, err := saveWebAuthnSession(nil, ceremonyLogin, data)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start login",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"session_id": sessionID,
+		"options":    options,
+	})
+}
+
+// FinishWebAuthnLogin verifies a passkey assertion and logs the user in. A
+// user-verified passkey is itself multi-factor, so no further challenge is sent.
+func FinishWebAuthnLogin(c *fiber.Ctx) error {
+	rp, err := getWebAuthn()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "WebAuthn is not available",
+		})
+	}
+
+	_, data, err := loadWebAuthnSession(c, ceremonyLogin)
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired login session",
+		})
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(c.Body())
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid credential",
+		})
+	}
+
+	var owner *webAuthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		user, err := loadWebAuthnUser(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		owner = user
+		return user, nil
+	}
+
+	_, credential, err := rp.ValidatePasskeyLogin(handler, *data, parsed)
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Passkey verification failed",
+		})
+	}
+
+	updateWebAuthnCredential(c, owner.user.ID, credential)
+
+	if !owner.user.IsVerified {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Please verify your email before logging in",
+		})
+	}
+
+	return respondWithTokens(c, owner.user, c.Query("device_name"))
+}
+
+// BeginWebAuthnMFA starts a security key assertion as the second factor of a
+// password login, for the user of the given MFA challenge
+func BeginWebAuthnMFA(c *fiber.Ctx) error {
+	var req WebAuthnLoginBeginRequest
+
+	rp, err := getWebAuthn()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "WebAuthn is not available",
+		})
+	}
+
+	if err := c.BodyParser(&req); err != nil || req.MFAToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "MFA token is required",
+		})
+	}
+
+	challenge, err := models.GetMFAChallengeByHash(utils.HashToken(req.MFAToken))
+	if err != nil || time.Now().After(challenge.ExpiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired MFA token",
+		})
+	}
+
+	user, err := loadWebAuthnUser(challenge.UserID)
+	if err != nil || len(user.credentials) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No security keys registered",
+		})
+	}
+
+	options, data, err := rp.BeginLogin(user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start verification",
+		})
+	}
+
+	sessionID, err := saveWebAuthnSession(&user.user.ID, ceremonyMFA, data)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start verification",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"session_id": sessionID,
+		"options":    options,
+	})
+}
+
+// FinishWebAuthnMFA verifies the security key assertion and exchanges the MFA
+// challenge (?mfa_token=) for the access/refresh token pair
+func FinishWebAuthnMFA(c *fiber.Ctx) error {
+	rp, err := getWebAuthn()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "WebAuthn is not available",
+		})
+	}
+
+	challenge, err := models.GetMFAChallengeByHash(utils.HashToken(c.Query("mfa_token")))
+	if err != nil || time.Now().After(challenge.ExpiresAt) {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired MFA token",
+		})
+	}
+
+	session, data, err := loadWebAuthnSession(c, ceremonyMFA)
+	if err != nil || session.UserID == nil || *session.UserID != challenge.UserID {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired verification session",
+		})
+	}
+
+	user, err := loadWebAuthnUser(challenge.UserID)
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired MFA token",
+		})
+	}
+
+	retryAfter, scope, err := loginRetryAfter(c, user.user)
+	if err != nil {
+		log.Printf("Failed to check login throttling: %v", err)
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to process login",
+		})
+	}
+	if retryAfter > 0 {
+		metrics.RecordAuthLogin(false)
+		metrics.RecordAuthLoginThrottled(scope)
+		return tooManyLoginAttempts(c, retryAfter, "Too many failed login attempts, try again later")
+	}
+
+	if ok, err := claimMFAAttempt(c, challenge); !ok {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(c.Body())
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid credential",
+		})
+	}
+
+	credential, err := rp.ValidateLogin(user, *data, parsed)
+	if err != nil {
+		metrics.RecordAuthLogin(false)
+		if recordLoginFailure(c, user.user) {
+			models.DeleteMFAChallenge(challenge.ID)
+			return tooManyLoginAttempts(c, config.GetEnvDuration("LOGIN_LOCK_DURATION", 15*time.Minute),
+				"Account locked after too many failed login attempts")
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Security key verification failed",
+		})
+	}
+
+	updateWebAuthnCredential(c, user.user.ID, credential)
+
+	if err := models.DeleteMFAChallenge(challenge.ID); err != nil {
+		log.Printf("Failed to delete MFA challenge: %v", err)
+	}
+	if user.user.FailedLoginAttempts > 0 {
+		if err := models.ResetUserLoginFailures(user.user.ID); err != nil {
+			log.Printf("Failed to reset failed login counter: %v", err)
+		}
+	}
+
+	return respondWithTokens(c, user.user, challenge.DeviceName)
+}
+
+// ListWebAuthnCredentials lists the current user's passkeys
+func ListWebAuthnCredentials(c *fiber.Ctx) error {
+	credentials, err := models.ListWebAuthnCredentials(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list passkeys",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"credentials": credentials,
+	})
+}
+
+// RenameWebAuthnCredential changes the display name of one of the current user's passkeys
+func RenameWebAuthnCredential(c *fiber.Ctx) error {
+	var req RenameWebAuthnCredentialRequest
+
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required",
+		})
+	}
+
+	id := c.Params("id")
+	if !utils.IsUUID(id) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Passkey not found",
+		})
+	}
+
+	renamed, err := models.RenameWebAuthnCredential(middleware.GetUserID(c), id, strings.TrimSpace(req.Name))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to rename passkey",
+		})
+	}
+	if !renamed {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Passkey not found",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Passkey renamed successfully",
+	})
+}
+
+// DeleteWebAuthnCredential removes one of the current user's passkeys after
+// checking their password
+func DeleteWebAuthnCredential(c *fiber.Ctx) error {
+	var req WebAuthnReauthRequest
+	userID := middleware.GetUserID(c)
+
+	if err := c.BodyParser(&req); err != nil || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Password is required",
+		})
+	}
+
+	id := c.Params("id")
+	if !utils.IsUUID(id) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Passkey not found",
+		})
+	}
+
+	user, err := models.GetUserByID(userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if ok, err := verifyCurrentPassword(c, user, req.Password); !ok {
+		return err
+	}
+
+	deleted, err := models.DeleteWebAuthnCredential(userID, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to remove passkey",
+		})
+	}
+	if !deleted {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Passkey not found",
+		})
+	}
+
+	recordSecurityEvent(c, userID, "webauthn.credential_removed", fiber.Map{"credential_id": id})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Passkey removed successfully",
+	})
+}
+
+// updateWebAuthnCredential stores the new sign count after an assertion and
+// flags authenticators that look cloned
+func updateWebAuthnCredential(c *fiber.Ctx, userID string, credential *webauthn.Credential) {
+	if credential.Authenticator.CloneWarning {
+		recordSecurityEvent(c, userID, "webauthn.clone_warning", fiber.Map{
+			"sign_count": credential.Authenticator.SignCount,
+		})
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		return
+	}
+
+	if err := models.UpdateWebAuthnCredentialUsage(credential.ID, encoded); err != nil {
+		log.Printf("Failed to update passkey: %v", err)
+	}
+}
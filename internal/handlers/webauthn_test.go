@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"auth-api/internal/models"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+// softAuthenticator is a software passkey: an ECDSA P-256 key with "none"
+// attestation, answering ceremonies the way a browser and authenticator would
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+	rpID         string
+}
+
+func newSoftAuthenticator(t *testing.T, userID string) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{
+		t:            t,
+		key:          key,
+		credentialID: credentialID,
+		userHandle:   []byte(userID),
+		origin:       "http://localhost:3000",
+		rpID:         "localhost",
+	}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge.String(),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// create answers a registration ceremony with a PublicKeyCredential
+func (a *softAuthenticator) create(options *protocol.CredentialCreation) []byte {
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(flagUserPresent|flagUserVerified|flagAttestedCredData, attested),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", options.Response.Challenge)),
+			"attestationObject": b64(attestation),
+		},
+	})
+}
+
+// get answers a login ceremony with an assertion signed by the key
+func (a *softAuthenticator) get(options *protocol.CredentialAssertion, flags byte) []byte {
+	a.signCount++
+	authData := a.authData(flags, nil)
+	clientData := a.clientData("webauthn.get", options.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+}
+
+func (a *softAuthenticator) marshal(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return data
+}
+
+// roundTripSession stores and loads ceremony state the way
+// saveWebAuthnSession and loadWebAuthnSession do
+func roundTripSession(t *testing.T, data *webauthn.SessionData) webauthn.SessionData {
+	t.Helper()
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded webauthn.SessionData
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func testRelyingParty(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+	rp, err := getWebAuthn()
+	if err != nil {
+		t.Fatalf("getWebAuthn() error = %v", err)
+	}
+	return rp
+}
+
+func testWebAuthnUser() *webAuthnUser {
+	return &webAuthnUser{user: &models.User{
+		ID:    "519e1710-d9f2-40d2-9755-30334dbfa6d6",
+		Email: "user@example.com",
+	}}
+}
+
+// register runs a registration ceremony and stores the credential on the
+// user the way FinishWebAuthnRegistration and loadWebAuthnUser do
+func register(t *testing.T, rp *webauthn.WebAuthn, user *webAuthnUser, authenticator *softAuthenticator) {
+	t.Helper()
+	options, data, err := rp.BeginRegistration(user, webAuthnRegistrationOptions(user)...)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(authenticator.create(options))
+	if err != nil {
+		t.Fatalf("ParseCredentialCreationResponseBytes() error = %v", err)
+	}
+	credential, err := rp.CreateCredential(user, roundTripSession(t, data), parsed)
+	if err != nil {
+		t.Fatalf("CreateCredential() error = %v", err)
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := []models.WebAuthnCredential{{CredentialID: credential.ID, Data: encoded}}
+	decoded, err := decodeWebAuthnCredentials(stored)
+	if err != nil {
+		t.Fatalf("decodeWebAuthnCredentials() error = %v", err)
+	}
+	user.credentials = append(user.credentials, decoded...)
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	rp := testRelyingParty(t)
+	user := testWebAuthnUser()
+	authenticator := newSoftAuthenticator(t, user.user.ID)
+
+	register(t, rp, user, authenticator)
+
+	if len(user.credentials) != 1 || !bytes.Equal(user.credentials[0].ID, authenticator.credentialID) {
+		t.Fatalf("credentials = %+v, want the authenticator's credential", user.credentials)
+	}
+
+	// A second registration must exclude the passkey the user already has
+	options, _, err := rp.BeginRegistration(user, webAuthnRegistrationOptions(user)...)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+	excluded := options.Response.CredentialExcludeList
+	if len(excluded) != 1 || !bytes.Equal(excluded[0].CredentialID, authenticator.credentialID) {
+		t.Errorf("excludeCredentials = %+v, want the registered credential", excluded)
+	}
+}
+
+func TestWebAuthnRegistrationRejectsWrongChallenge(t *testing.T) {
+	rp := testRelyingParty(t)
+	user := testWebAuthnUser()
+	authenticator := newSoftAuthenticator(t, user.user.ID)
+
+	options, _, err := rp.BeginRegistration(user, webAuthnRegistrationOptions(user)...)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+	// The answer belongs to a different ceremony than the stored session
+	_, other, err := rp.BeginRegistration(user, webAuthnRegistrationOptions(user)...)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(authenticator.create(options))
+	if err != nil {
+		t.Fatalf("ParseCredentialCreationResponseBytes() error = %v", err)
+	}
+	if _, err := rp.CreateCredential(user, roundTripSession(t, other), parsed); err == nil {
+		t.Error("CreateCredential() accepted a credential for another challenge")
+	}
+}
+
+func TestWebAuthnPasskeyLogin(t *testing.T) {
+	rp := testRelyingParty(t)
+	user := testWebAuthnUser()
+	authenticator := newSoftAuthenticator(t, user.user.ID)
+	register(t, rp, user, authenticator)
+
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if string(userHandle) != user.user.ID {
+			t.Fatalf("userHandle = %q, want %q", userHandle, user.user.ID)
+		}
+		return user, nil
+	}
+
+	tests := []struct {
+		name    string
+		flags   byte
+		wantErr bool
+	}{
+		{"user verified", flagUserPresent | flagUserVerified, false},
+		{"user not verified", flagUserPresent, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, data, err := rp.BeginDiscoverableLogin(webAuthnPasskeyLoginOptions()...)
+			if err != nil {
+				t.Fatalf("BeginDiscoverableLogin() error = %v", err)
+			}
+			if len(options.Response.AllowedCredentials) != 0 {
+				t.Errorf("allowCredentials = %+v, want none for a discoverable login", options.Response.AllowedCredentials)
+			}
+
+			parsed, err := protocol.ParseCredentialRequestResponseBytes(authenticator.get(options, tt.flags))
+			if err != nil {
+				t.Fatalf("ParseCredentialRequestResponseBytes() error = %v", err)
+			}
+			_, credential, err := rp.ValidatePasskeyLogin(handler, roundTripSession(t, data), parsed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidatePasskeyLogin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && credential.Authenticator.SignCount != authenticator.signCount {
+				t.Errorf("sign count = %d, want %d", credential.Authenticator.SignCount, authenticator.signCount)
+			}
+		})
+	}
+}
+
+func TestWebAuthnMFA(t *testing.T) {
+	rp := testRelyingParty(t)
+	user := testWebAuthnUser()
+	authenticator := newSoftAuthenticator(t, user.user.ID)
+	register(t, rp, user, authenticator)
+
+	// A key the user never registered, carrying the registered credential ID
+	impostor := newSoftAuthenticator(t, user.user.ID)
+	impostor.credentialID = authenticator.credentialID
+
+	tests := []struct {
+		name          string
+		authenticator *softAuthenticator
+		wantErr       bool
+	}{
+		{"registered key", authenticator, false},
+		{"unregistered key", impostor, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, data, err := rp.BeginLogin(user)
+			if err != nil {
+				t.Fatalf("BeginLogin() error = %v", err)
+			}
+			allowed := options.Response.AllowedCredentials
+			if len(allowed) != 1 || !bytes.Equal(allowed[0].CredentialID, authenticator.credentialID) {
+				t.Errorf("allowCredentials = %+v, want the registered credential", allowed)
+			}
+
+			// A security key as second factor only needs user presence
+			parsed, err := protocol.ParseCredentialRequestResponseBytes(tt.authenticator.get(options, flagUserPresent))
+			if err != nil {
+				t.Fatalf("ParseCredentialRequestResponseBytes() error = %v", err)
+			}
+			if _, err := rp.ValidateLogin(user, roundTripSession(t, data), parsed); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateLogin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
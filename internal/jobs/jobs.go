@@ -0,0 +1,180 @@
+package jobs
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/kafka"
+	"auth-api/internal/metrics"
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"fmt"
+	"log"
+	"time"
+)
+
+// StartScheduler starts the periodic background jobs
+func StartScheduler() {
+	// Reloading the key ring every minute lets every instance pick up keys
+	// rotated by another one; RotateSigningKeys only creates a key when due.
+	every("signing key rotation", config.GetEnvDuration("JWT_KEY_REFRESH_INTERVAL", time.Minute), func() error {
+		if err := models.RotateSigningKeys(); err != nil {
+			return err
+		}
+		return models.DeleteExpiredSigningKeys()
+	})
+
+	// Expired tokens can no longer be used, so their rows are only clutter
+	every("expired token cleanup", config.GetEnvDuration("TOKEN_CLEANUP_INTERVAL", time.Hour), func() error {
+		models.PruneAccessTokenCache()
+		models.PrunePermissionCache()
+		if err := models.DeleteExpiredAccessTokens(); err != nil {
+			return err
+		}
+		if err := models.DeleteExpiredRefreshTokens(); err != nil {
+			return err
+		}
+		if err := models.DeleteExpiredMFAChallenges(); err != nil {
+			return err
+		}
+		if err := models.DeleteExpiredWebAuthnSessions(); err != nil {
+			return err
+		}
+		if err := models.DeleteExpiredCodes(); err != nil {
+			return err
+		}
+		if err := models.DeleteExpiredDataExports(); err != nil {
+			return err
+		}
+		if err := models.DeleteExpiredOrganizationInvitations(); err != nil {
+			return err
+		}
+		if err := models.ClearExpiredUserStatuses(); err != nil {
+			return err
+		}
+		return models.DeleteStaleLoginIPAttempts(config.GetEnvDuration("LOGIN_IP_WINDOW", 15*time.Minute))
+	})
+
+	// Accounts whose deletion grace period is over are removed for good
+	every("account purge", config.GetEnvDuration("ACCOUNT_PURGE_INTERVAL", time.Hour), purgeDeletedAccounts)
+
+	// Events written to the outbox are published here, and retried until
+	// Kafka accepts them
+	every("event outbox", config.GetEnvDuration("EVENT_OUTBOX_INTERVAL", 30*time.Second), publishOutboxEvents)
+
+	// Large personal data exports are built here instead of in the request
+	every("data export", config.GetEnvDuration("DATA_EXPORT_POLL_INTERVAL", 30*time.Second), processDataExports)
+
+	log.Println("⏰ Background jobs started")
+}
+
+// purgeDeletedAccounts purges soft-deleted accounts in batches. Each purge
+// leaves a user.deleted event in the outbox so other services can drop their
+// data; the events are published right after the batch and retried by the
+// outbox job when that fails.
+func purgeDeletedAccounts() error {
+	for {
+		users, err := models.ListUsersDueForPurge(100)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		for i := range users {
+			user := &users[i]
+			purged, err := models.PurgeUser(user)
+			if err != nil {
+				return err
+			}
+			if purged {
+				metrics.RecordSecurityEvent("user.deleted")
+			}
+		}
+
+		if err := publishOutboxEvents(); err != nil {
+			log.Printf("Failed to publish outbox events: %v", err)
+		}
+	}
+}
+
+// publishOutboxEvents relays the event outbox to Kafka until it is empty or
+// publishing fails
+func publishOutboxEvents() error {
+	for {
+		published, err := models.PublishOutboxEvents(100, func(event *models.OutboxEvent) error {
+			return kafka.PublishEventAt(event.Type, event.UserID, event.OccurredAt, event.Data)
+		})
+		if err != nil || published == 0 {
+			return err
+		}
+	}
+}
+
+// processDataExports builds queued data exports one at a time and emails the
+// owner a download link for each. The link's token is only ever sent by
+// email; the table keeps its hash.
+func processDataExports() error {
+	for {
+		job, err := models.ClaimDataExport(config.GetEnvDuration("DATA_EXPORT_STALE_AFTER", 30*time.Minute))
+		if err != nil {
+			if models.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+
+		if err := buildDataExport(job); err != nil {
+			log.Printf("Data export %s failed: %v", job.ID, err)
+			if err := models.FailDataExport(job.ID, err.Error()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func buildDataExport(job *models.DataExport) error {
+	export, err := models.CollectUserData(job.UserID)
+	if err != nil {
+		return err
+	}
+
+	archive, err := export.Archive(job.Format)
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(config.GetEnvDuration("DATA_EXPORT_TTL", 7*24*time.Hour))
+	if err := models.CompleteDataExport(job.ID, archive, utils.HashToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	link := config.GetEnv("DATA_EXPORT_DOWNLOAD_URL", "http://localhost:8080/exports") + "/" + job.ID + "/download?token=" + token
+	// The email holds the only copy of the token, so an export whose email
+	// was not sent can never be downloaded. It is marked failed instead,
+	// letting the user request a new one.
+	if err := kafka.SendDataExportReadyEmail(export.User.Email, link, expiresAt); err != nil {
+		metrics.RecordEmailSent("data_export", false)
+		return fmt.Errorf("failed to send the download link: %w", err)
+	}
+	metrics.RecordEmailSent("data_export", true)
+	return nil
+}
+
+// every runs fn on a fixed interval in its own goroutine
+func every(name string, interval time.Duration, fn func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := fn(); err != nil {
+				log.Printf("Job %q failed: %v", name, err)
+			}
+		}
+	}()
+}
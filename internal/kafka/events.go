@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// EventWriter publishes account and security events for downstream services
+var EventWriter *kafka.Writer
+
+// Event is the payload published on the events topic
+type Event struct {
+    Type       string                 `json:"type"`
+    UserID     string                 `json:"user_id,omitempty"`
+    OccurredAt time.Time              `json:"occurred_at"`
+    Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+func initEventWriter() {
+    topic := os.Getenv("KAFKA_EVENTS_TOPIC")
+    if topic == "" {
+        topic = "auth-events"
+    }
+
+    EventWriter = kafka.NewWriter(kafka.WriterConfig{
+        Brokers:  []string{os.Getenv("KAFKA_BROKER")},
+        Topic:    topic,
+        Balancer: &kafka.Hash{},
+    })
+    log.Println("📬 Kafka event producer initialized on topic", topic)
+}
+
+// PublishEvent publishes an event keyed by user so a user's events stay ordered
+func PublishEvent(eventType, userID string, data map[string]interface{}) error {
+    return PublishEventAt(eventType, userID, time.Now(), data)
+}
+
+// PublishEventAt publishes an event that happened earlier, such as one
+// relayed from the event outbox
+func PublishEventAt(eventType, userID string, occurredAt time.Time, data map[string]interface{}) error {
+    event := Event{
+        Type:       eventType,
+        UserID:     userID,
+        OccurredAt: occurredAt.UTC(),
+        Data:       data,
+    }
+
+    jsonData, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+
+    msg := kafka.Message{
+        Key:   []byte(userID),
+        Value: jsonData,
+    }
+
+    return EventWriter.WriteMessages(context.Background(), msg)
+}
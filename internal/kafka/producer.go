@@ -3,8 +3,10 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
@@ -18,6 +20,23 @@ func InitProducer() {
         Balancer: &kafka.LeastBytes{},
     })
     log.Println("📬 Kafka producer initialized")
+
+    initEventWriter()
+}
+
+// publishEmail queues an email for the email consumer
+func publishEmail(payload EmailPayload) error {
+    jsonData, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+
+    msg := kafka.Message{
+        Key:   []byte(payload.To),
+        Value: jsonData,
+    }
+
+    return Writer.WriteMessages(context.Background(), msg)
 }
 
 func SendOTPEmail(toEmail, otp string) error {
@@ -59,3 +78,81 @@ func SendPasswordResetOTPEmail(toEmail, otp string) error {
 
     return Writer.WriteMessages(context.Background(), msg)
 }
+
+func SendLoginCodeEmail(toEmail, code string) error {
+    return publishEmail(EmailPayload{
+        To:      toEmail,
+        Subject: "Your login code",
+        Body:    "Your login code is: " + code + "\n\nIf you didn't try to log in, you can ignore this email.",
+    })
+}
+
+func SendMagicLinkEmail(toEmail, link string) error {
+    return publishEmail(EmailPayload{
+        To:      toEmail,
+        Subject: "Your login link",
+        Body:    "Click the link below to log in. It can be used once and only from the browser where you requested it:\n\n" + link + "\n\nIf you didn't try to log in, you can ignore this email.",
+    })
+}
+
+func SendEmailChangeCodeEmail(toEmail, code string) error {
+    return publishEmail(EmailPayload{
+        To:      toEmail,
+        Subject: "Confirm your new email address",
+        Body:    "Your email change confirmation code is: " + code + "\n\nThis code will expire in 15 minutes.",
+    })
+}
+
+func SendEmailChangeNoticeEmail(toEmail, newEmail, cancelLink string) error {
+    return publishEmail(EmailPayload{
+        To:      toEmail,
+        Subject: "Your email address is about to change",
+        Body:    "Someone asked to change the email address of your account to " + newEmail + ".\n\nIf this wasn't you, cancel the change here and reset your password:\n\n" + cancelLink,
+    })
+}
+
+func SendAccountDeletionScheduledEmail(toEmail string, purgeAfter time.Time) error {
+    return publishEmail(EmailPayload{
+        To:      toEmail,
+        Subject: "Your account is scheduled for deletion",
+        Body: fmt.Sprintf("Your account and its data will be permanently deleted on %s.\n\nYou can restore the account until then from the login page.",
+            purgeAfter.UTC().Format(time.RFC1123)),
+    })
+}
+
+func SendSecurityAlertEmail(toEmail, subject, message string) error {
+    return publishEmail(EmailPayload{
+        To:      toEmail,
+        Subject: subject,
+        Body:    message + "\n\nIf this wasn't you, reset your password and review your active sessions.",
+    })
+}
+
+func SendAccountLockedEmail(toEmail string, lockedUntil time.Time) error {
+    return SendSecurityAlertEmail(toEmail, "Your account has been temporarily locked",
+        fmt.Sprintf("Your account was locked after too many failed login attempts. You can log in again after %s.",
+            lockedUntil.UTC().Format(time.RFC1123)))
+}
+
+func SendPasswordChangedEmail(toEmail string) error {
+    return SendSecurityAlertEmail(toEmail, "Your password was changed",
+        "The password for your account was just changed and your other sessions were signed out.")
+}
+
+func SendDataExportReadyEmail(toEmail, downloadLink string, expiresAt time.Time) error {
+    return publishEmail(EmailPayload{
+        To:      toEmail,
+        Subject: "Your data export is ready",
+        Body: fmt.Sprintf("The export of your account data is ready to download:\n\n%s\n\nThe link works until %s.",
+            downloadLink, expiresAt.UTC().Format(time.RFC1123)),
+    })
+}
+
+func SendOrganizationInvitationEmail(toEmail, organizationName, acceptLink string, expiresAt time.Time) error {
+    return publishEmail(EmailPayload{
+        To:      toEmail,
+        Subject: "You have been invited to join " + organizationName,
+        Body: fmt.Sprintf("You have been invited to join %s. Sign in or create an account with this email address, then accept the invitation here:\n\n%s\n\nThe invitation expires on %s.",
+            organizationName, acceptLink, expiresAt.UTC().Format(time.RFC1123)),
+    })
+}
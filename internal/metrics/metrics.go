@@ -44,6 +44,30 @@ var (
 		[]string{"status"},
 	)
 
+	AuthLockoutsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_lockouts_total",
+			Help: "Total number of account locks and IP blocks after repeated failed logins",
+		},
+		[]string{"scope"},
+	)
+
+	AuthLoginThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_login_throttled_total",
+			Help: "Total number of login attempts rejected by backoff, lockout or IP block",
+		},
+		[]string{"scope"},
+	)
+
+	AuthVerificationResendTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_verification_resend_total",
+			Help: "Total number of verification code resend requests by outcome",
+		},
+		[]string{"result"},
+	)
+
 	AuthPasswordResetTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "auth_password_reset_total",
@@ -52,6 +76,14 @@ var (
 		[]string{"status"},
 	)
 
+	SecurityEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_security_events_total",
+			Help: "Total number of security events by type",
+		},
+		[]string{"type"},
+	)
+
 	// Database metrics
 	DatabaseOperationsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -118,7 +150,11 @@ func RegisterMetrics() {
 		HttpRequestDuration,
 		AuthSignupTotal,
 		AuthLoginTotal,
+		AuthLockoutsTotal,
+		AuthLoginThrottledTotal,
+		AuthVerificationResendTotal,
 		AuthPasswordResetTotal,
+		SecurityEventsTotal,
 		DatabaseOperationsTotal,
 		DatabaseOperationDuration,
 		EmailSentTotal,
@@ -172,6 +208,21 @@ func RecordAuthLogin(success bool) {
 	AuthLoginTotal.WithLabelValues(status).Inc()
 }
 
+// RecordAuthLockout records an account lock ("user") or IP block ("ip")
+func RecordAuthLockout(scope string) {
+	AuthLockoutsTotal.WithLabelValues(scope).Inc()
+}
+
+// RecordAuthLoginThrottled records a login rejected before the password was checked
+func RecordAuthLoginThrottled(scope string) {
+	AuthLoginThrottledTotal.WithLabelValues(scope).Inc()
+}
+
+// RecordVerificationResend records the outcome of a verification code resend
+func RecordVerificationResend(result string) {
+	AuthVerificationResendTotal.WithLabelValues(result).Inc()
+}
+
 // RecordAuthPasswordReset records password reset metrics
 func RecordAuthPasswordReset(success bool) {
 	status := "failure"
@@ -181,6 +232,11 @@ func RecordAuthPasswordReset(success bool) {
 	AuthPasswordResetTotal.WithLabelValues(status).Inc()
 }
 
+// RecordSecurityEvent records security event metrics
+func RecordSecurityEvent(eventType string) {
+	SecurityEventsTotal.WithLabelValues(eventType).Inc()
+}
+
 // RecordDatabaseOperation records database operation metrics
 func RecordDatabaseOperation(operation string, success bool, duration time.Duration) {
 	status := "failure"
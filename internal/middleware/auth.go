@@ -1,7 +1,11 @@
 package middleware
 
 import (
+	"auth-api/internal/config"
+	"auth-api/internal/metrics"
+	"auth-api/internal/models"
 	"auth-api/internal/utils"
+	"log"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -36,22 +40,52 @@ func AuthMiddleware() fiber.Handler {
 			// Validate token
 	claims, err := utils.ValidateToken(token)
 	if err != nil {
+		metrics.RecordJWTTokenValidated(false)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid or expired token",
 		})
 	}
 
+	// Reject tokens that were revoked (logout, password reset, admin)
+	active, err := models.IsAccessTokenActive(utils.HashToken(token))
+	if err != nil {
+		log.Printf("Failed to check access token revocation: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to validate token",
+		})
+	}
+	if !active {
+		metrics.RecordJWTTokenValidated(false)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Token has been revoked",
+		})
+	}
 
+	metrics.RecordJWTTokenValidated(true)
 
 	// Add user info to context
 	c.Locals("user_id", claims.UserID)
 	c.Locals("user_email", claims.Email)
 	c.Locals("user_role", claims.Role)
+	c.Locals("user_roles", claims.Roles)
+	c.Locals("user_permissions", claims.Permissions)
+	c.Locals("session_id", claims.SessionID)
+	c.Locals("org_id", claims.OrganizationID)
+	c.Locals("org_role", claims.OrgRole)
 
 		return c.Next()
 	}
 }
 
+// BearerToken returns the token from a "Bearer" Authorization header, or ""
+func BearerToken(c *fiber.Ctx) string {
+	authHeader := c.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(authHeader, "Bearer ")
+}
+
 // RoleMiddleware checks if user has required role
 func RoleMiddleware(requiredRole string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -62,7 +96,7 @@ func RoleMiddleware(requiredRole string) fiber.Handler {
 			})
 		}
 
-		if userRole != requiredRole {
+		if !HasRole(c, requiredRole) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Insufficient permissions",
 			})
@@ -72,7 +106,130 @@ func RoleMiddleware(requiredRole string) fiber.Handler {
 	}
 }
 
-// AdminMiddleware checks if user is admin
+// RequirePermission checks that one of the user's roles grants a permission
+// such as "users:write". Permissions come from the access token, or are
+// looked up (and cached) when the token does not carry them.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Locals("user_id") == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "User not authenticated",
+			})
+		}
+
+		granted, err := HasPermission(c, permission)
+		if err != nil {
+			log.Printf("Failed to load permissions: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check permissions",
+			})
+		}
+		if !granted {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":      "Insufficient permissions",
+				"permission": permission,
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// HasRole reports whether the user has a role, directly or through a higher
+// role in ROLE_HIERARCHY. Tokens issued before roles were embedded only carry
+// the primary role.
+func HasRole(c *fiber.Ctx, role string) bool {
+	for _, userRole := range GetUserRoles(c) {
+		for _, implied := range config.ImpliedRoles(userRole) {
+			if implied == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the user's permissions include a permission,
+// either by name or through a wildcard pattern such as "users:*"
+func HasPermission(c *fiber.Ctx, permission string) (bool, error) {
+	permissions, err := GetUserPermissions(c)
+	if err != nil {
+		return false, err
+	}
+
+	for _, pattern := range permissions {
+		if permissionMatches(pattern, permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// permissionMatches reports whether a granted permission pattern covers a
+// permission. A "*" segment matches any one segment, and a trailing "*"
+// matches everything from there on, so "*" alone matches every permission.
+func permissionMatches(pattern, permission string) bool {
+	if pattern == permission {
+		return true
+	}
+
+	patternParts := strings.Split(pattern, ":")
+	permissionParts := strings.Split(permission, ":")
+	for i, part := range patternParts {
+		if i >= len(permissionParts) {
+			return false
+		}
+		if part == "*" && i == len(patternParts)-1 {
+			return true
+		}
+		if part != "*" && part != permissionParts[i] {
+			return false
+		}
+	}
+	return len(patternParts) == len(permissionParts)
+}
+
+// RequireOrganization scopes a request to the organization the caller's
+// session works in. Requests from users without one are rejected.
+func RequireOrganization() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Locals("user_id") == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "User not authenticated",
+			})
+		}
+
+		if GetOrganizationID(c) == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "No active organization",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireOrgRole checks the caller's role in their active organization. Owners
+// pass admin checks and admins pass member checks.
+func RequireOrgRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if GetOrganizationID(c) == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "No active organization",
+			})
+		}
+
+		if models.OrgRoleRank(GetOrgRole(c)) < models.OrgRoleRank(role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient organization role",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// AdminMiddleware checks if user is admin, or has a role above admin
 func AdminMiddleware() fiber.Handler {
 	return RoleMiddleware("admin")
 }
@@ -87,7 +244,45 @@ func GetUserEmail(c *fiber.Ctx) string {
 	return c.Locals("user_email").(string)
 }
 
+// GetSessionID returns the current session (refresh token family) ID from context
+func GetSessionID(c *fiber.Ctx) string {
+	return c.Locals("session_id").(string)
+}
+
+// GetOrganizationID returns the caller's active organization ID from context,
+// or "" when they have none
+func GetOrganizationID(c *fiber.Ctx) string {
+	organizationID, _ := c.Locals("org_id").(string)
+	return organizationID
+}
+
+// GetOrgRole returns the caller's role in their active organization
+func GetOrgRole(c *fiber.Ctx) string {
+	role, _ := c.Locals("org_role").(string)
+	return role
+}
+
 // GetUserRole returns user role from context
 func GetUserRole(c *fiber.Ctx) string {
 	return c.Locals("user_role").(string)
+}
+
+// GetUserRoles returns all of the user's roles from context
+func GetUserRoles(c *fiber.Ctx) []string {
+	roles, _ := c.Locals("user_roles").([]string)
+	if len(roles) == 0 {
+		if role, ok := c.Locals("user_role").(string); ok && role != "" {
+			return []string{role}
+		}
+	}
+	return roles
+}
+
+// GetUserPermissions returns the user's permissions from the access token,
+// or looks them up when the token does not carry them
+func GetUserPermissions(c *fiber.Ctx) ([]string, error) {
+	if permissions, _ := c.Locals("user_permissions").([]string); permissions != nil {
+		return permissions, nil
+	}
+	return models.GetUserPermissions(GetUserID(c))
 } 
\ No newline at end of file
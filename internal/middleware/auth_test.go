@@ -0,0 +1,51 @@
+package middleware
+
+import "testing"
+
+func TestPermissionMatches(t *testing.T) {
+	tests := []struct {
+		pattern, permission string
+		want                bool
+	}{
+		// Exact names
+		{"users:read", "users:read", true},
+		{"users:read", "users:write", false},
+		{"users:read", "sessions:read", false},
+
+		// "*" alone grants everything
+		{"*", "users", true},
+		{"*", "users:read", true},
+		{"*", "users:read:own", true},
+
+		// A trailing wildcard covers the rest of the name, but not the bare prefix
+		{"users:*", "users:read", true},
+		{"users:*", "users:read:own", true},
+		{"users:*", "users", false},
+		{"users:*", "sessions:read", false},
+		{"users:*", "usersx:read", false},
+
+		// A leading wildcard stands for exactly one segment
+		{"*:read", "users:read", true},
+		{"*:read", "sessions:read", true},
+		{"*:read", "users:write", false},
+		{"*:read", "users", false},
+		{"*:read", "users:read:own", false},
+		{"*:read:own", "users:read:own", true},
+
+		// Names shorter or longer than the pattern
+		{"users:read", "users", false},
+		{"users:read", "users:read:own", false},
+		{"users:read:own", "users:read", false},
+		{"users", "users:read", false},
+
+		{"", "users:read", false},
+		{"users:read", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.permission, func(t *testing.T) {
+			if got := permissionMatches(tt.pattern, tt.permission); got != tt.want {
+				t.Errorf("permissionMatches(%q, %q) = %v, want %v", tt.pattern, tt.permission, got, tt.want)
+			}
+		})
+	}
+}
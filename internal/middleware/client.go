@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"auth-api/internal/models"
+	"auth-api/internal/utils"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ClientAuthMiddleware authenticates an OAuth client with HTTP Basic
+// credentials or client_id/client_secret form fields (RFC 6749 section 2.3.1)
+func ClientAuthMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		clientID, clientSecret, ok := basicCredentials(c)
+		if !ok {
+			clientID = c.FormValue("client_id")
+			clientSecret = c.FormValue("client_secret")
+		}
+
+		if clientID == "" || clientSecret == "" {
+			return invalidClient(c)
+		}
+
+		client, err := models.GetOAuthClientByClientID(clientID)
+		if err != nil {
+			return invalidClient(c)
+		}
+
+		secretHash := utils.HashToken(clientSecret)
+		if subtle.ConstantTimeCompare([]byte(secretHash), []byte(client.ClientSecretHash)) != 1 {
+			return invalidClient(c)
+		}
+
+		c.Locals("client_id", client.ClientID)
+		return c.Next()
+	}
+}
+
+// GetClientID returns the authenticated OAuth client ID from context
+func GetClientID(c *fiber.Ctx) string {
+	return c.Locals("client_id").(string)
+}
+
+func basicCredentials(c *fiber.Ctx) (string, string, bool) {
+	authHeader := c.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Basic ") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authHeader, "Basic "))
+	if err != nil {
+		return "", "", false
+	}
+
+	id, secret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", false
+	}
+
+	// Client credentials are form-encoded before being put in the header
+	if unescaped, err := url.QueryUnescape(id); err == nil {
+		id = unescaped
+	}
+	if unescaped, err := url.QueryUnescape(secret); err == nil {
+		secret = unescaped
+	}
+	return id, secret, true
+}
+
+func invalidClient(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth-api"`)
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error":             "invalid_client",
+		"error_description": "Client authentication failed",
+	})
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"time"
+)
+
+// SoftDeleteUser marks the account deleted and schedules its purge
+func SoftDeleteUser(userID string, purgeAfter time.Time) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET deleted_at = NOW(), purge_after = $2,
+		        status = $3, status_reason = 'Deletion requested', status_expires_at = $2, status_changed_at = NOW()
+		 WHERE id = $1 AND deleted_at IS NULL`,
+		userID, purgeAfter, StatusPendingDeletion,
+	)
+	return err
+}
+
+// RestoreUser cancels a pending deletion. It returns false when the account
+// was not deleted or its grace period is already over.
+func RestoreUser(userID string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET deleted_at = NULL, purge_after = NULL,
+		        status = CASE WHEN status = $2 THEN $3 ELSE status END,
+		        status_reason = CASE WHEN status = $2 THEN '' ELSE status_reason END,
+		        status_expires_at = CASE WHEN status = $2 THEN NULL ELSE status_expires_at END,
+		        status_changed_at = CASE WHEN status = $2 THEN NOW() ELSE status_changed_at END
+		 WHERE id = $1 AND deleted_at IS NOT NULL AND purge_after > NOW()`,
+		userID, StatusPendingDeletion, StatusActive,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ListUsersDueForPurge returns soft-deleted users whose grace period is over
+func ListUsersDueForPurge(limit int) ([]User, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT `+userColumns+` FROM users
+		 WHERE deleted_at IS NOT NULL AND purge_after <= NOW()
+		 ORDER BY purge_after LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// PurgeUser permanently removes a user. Tokens, sessions and second factors
+// go with the user row through ON DELETE CASCADE; one-time codes and
+// organization invitations are keyed by email or user ID and are deleted
+// explicitly. Audit events are kept for the security record but lose their
+// IP address, user agent and details. A user.deleted event is written to the
+// outbox in the same transaction, so other services hear of the purge even
+// when Kafka is down. It returns false when the account was restored in the
+// meantime.
+func PurgeUser(user *User) (bool, error) {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM one_time_codes WHERE subject IN ($1, $2)`, user.Email, user.ID,
+	); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM one_time_code_sends WHERE subject IN ($1, $2)`, user.Email, user.ID,
+	); err != nil {
+		return false, err
+	}
+	// Invitations name the user only by email, so nothing cascades to them
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM organization_invitations WHERE LOWER(email) = LOWER($1)`, user.Email,
+	); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE audit_events SET ip_address = NULL, user_agent = NULL, details = NULL WHERE user_id = $1`, user.ID,
+	); err != nil {
+		return false, err
+	}
+	tag, err := tx.Exec(ctx,
+		`DELETE FROM users WHERE id = $1 AND deleted_at IS NOT NULL AND purge_after <= NOW()`, user.ID,
+	)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if err := enqueueEvent(ctx, tx, "user.deleted", user.ID, map[string]interface{}{
+		"deleted_at": user.DeletedAt,
+		"purged_at":  time.Now(),
+	}); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+
+	evictAccessTokens(func(_ string, entry cachedAccessToken) bool {
+		return entry.userID == user.ID
+	})
+	return true, nil
+}
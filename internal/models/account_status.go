@@ -0,0 +1,79 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"time"
+)
+
+// Account statuses. Suspensions are set by admins; locks follow too many
+// failed logins and pending deletion follows a deletion request. Suspensions
+// and locks may expire.
+const (
+	StatusActive          = "active"
+	StatusSuspended       = "suspended"
+	StatusLocked          = "locked"
+	StatusPendingDeletion = "pending_deletion"
+)
+
+// expireStatus makes a suspension or lock past its expiry read as lifted, see
+// liftedStatus. The stored status is updated later by ClearExpiredUserStatuses.
+func (u *User) expireStatus(now time.Time) {
+	if u.Status != StatusSuspended && u.Status != StatusLocked {
+		return
+	}
+	if u.StatusExpiresAt == nil || now.Before(*u.StatusExpiresAt) {
+		return
+	}
+	if u.DeletedAt != nil {
+		u.Status, u.StatusReason, u.StatusExpiresAt = StatusPendingDeletion, "Deletion requested", u.PurgeAfter
+	} else {
+		u.Status, u.StatusReason, u.StatusExpiresAt = StatusActive, "", nil
+	}
+}
+
+// SuspendUser suspends an account, until the given time or, with a nil until,
+// until it is reactivated. A pending deletion stays scheduled and its status
+// returns once the suspension ends.
+func SuspendUser(userID, reason string, until *time.Time) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET status = $2, status_reason = $3, status_expires_at = $4,
+		        status_changed_at = NOW(), updated_at = NOW()
+		 WHERE id = $1`,
+		userID, StatusSuspended, reason, until,
+	)
+	return err
+}
+
+// liftedStatus is the SQL for the status an account returns to when its
+// suspension or lock ends: pending deletion while a deletion is scheduled,
+// active otherwise
+const liftedStatus = `
+	status = CASE WHEN deleted_at IS NOT NULL THEN 'pending_deletion' ELSE 'active' END,
+	status_reason = CASE WHEN deleted_at IS NOT NULL THEN 'Deletion requested' ELSE '' END,
+	status_expires_at = purge_after,
+	status_changed_at = NOW()`
+
+// ReactivateUser lifts a suspension. It returns false when the account was
+// not suspended.
+func ReactivateUser(userID string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET `+liftedStatus+`, updated_at = NOW()
+		 WHERE id = $1 AND status = $2`,
+		userID, StatusSuspended,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ClearExpiredUserStatuses ends suspensions and locks past their expiry
+func ClearExpiredUserStatuses() error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET `+liftedStatus+`
+		 WHERE status IN ($1, $2) AND status_expires_at <= NOW()`,
+		StatusSuspended, StatusLocked,
+	)
+	return err
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"time"
+)
+
+type AuditEvent struct {
+	ID        string                 `json:"id"`
+	UserID    *string                `json:"user_id"`
+	EventType string                 `json:"event_type"`
+	IPAddress string                 `json:"ip_address"`
+	UserAgent string                 `json:"user_agent"`
+	Details   map[string]interface{} `json:"details"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+func CreateAuditEvent(event *AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (user_id, event_type, ip_address, user_agent, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+	`
+	_, err := config.DB.Exec(context.Background(), query,
+		event.UserID, event.EventType, event.IPAddress, event.UserAgent, event.Details,
+	)
+	return err
+}
+
+// ListAuditEventsForUser returns the user's audit events, newest first
+func ListAuditEventsForUser(userID string) ([]AuditEvent, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT id, user_id, event_type, COALESCE(ip_address, ''), COALESCE(user_agent, ''), details, created_at
+		 FROM audit_events WHERE user_id = $1 ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		if err := rows.Scan(&event.ID, &event.UserID, &event.EventType, &event.IPAddress, &event.UserAgent,
+			&event.Details, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,235 @@
+package models
+
+import (
+	"archive/zip"
+	"auth-api/internal/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	ExportFormatJSON = "json"
+	ExportFormatZip  = "zip"
+
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// UserDataExport is everything the service holds about a user. Secrets
+// (password hash, TOTP secret, token and code hashes, passkey public keys)
+// are left out. The service keeps no consent records, so there is no
+// section for them.
+type UserDataExport struct {
+	ExportedAt  time.Time      `json:"exported_at"`
+	User        *User          `json:"user"`
+	Sessions    []RefreshToken `json:"sessions"`
+	AuditEvents []AuditEvent   `json:"audit_events"`
+	MFA         UserMFAExport  `json:"mfa"`
+}
+
+type UserMFAExport struct {
+	TOTP                *UserTOTP            `json:"totp"`
+	UnusedRecoveryCodes int                  `json:"unused_recovery_codes"`
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials"`
+}
+
+// DataExport is a personal data export job
+type DataExport struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Format      string     `json:"format"`
+	Status      string     `json:"status"`
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// CollectUserData gathers the user's data for an export
+func CollectUserData(userID string) (*UserDataExport, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &UserDataExport{ExportedAt: time.Now().UTC(), User: user}
+
+	if export.Sessions, err = ListRefreshTokensForUser(userID); err != nil {
+		return nil, err
+	}
+	if export.AuditEvents, err = ListAuditEventsForUser(userID); err != nil {
+		return nil, err
+	}
+
+	totp, err := GetUserTOTP(userID)
+	if err != nil && !isNoRows(err) {
+		return nil, err
+	}
+	export.MFA.TOTP = totp
+	if export.MFA.UnusedRecoveryCodes, err = CountUnusedRecoveryCodes(userID); err != nil {
+		return nil, err
+	}
+	if export.MFA.WebAuthnCredentials, err = ListWebAuthnCredentials(userID); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// CountUserDataRecords returns roughly how many rows an export of the user
+// contains; large exports are built in the background
+func CountUserDataRecords(userID string) (int, error) {
+	var count int
+	err := config.DB.QueryRow(context.Background(),
+		`SELECT (SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1)
+		      + (SELECT COUNT(*) FROM audit_events WHERE user_id = $1)`,
+		userID,
+	).Scan(&count)
+	return count, err
+}
+
+// Archive encodes the export as a single JSON document, or as a zip file with
+// one JSON document per section
+func (e *UserDataExport) Archive(format string) ([]byte, error) {
+	if format != ExportFormatZip {
+		return json.MarshalIndent(e, "", "  ")
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	sections := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", e.User},
+		{"sessions.json", e.Sessions},
+		{"audit_events.json", e.AuditEvents},
+		{"mfa.json", e.MFA},
+		{"export.json", map[string]interface{}{"exported_at": e.ExportedAt}},
+	}
+	for _, section := range sections {
+		data, err := json.MarshalIndent(section.data, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		file, err := archive.Create(section.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+const dataExportColumns = `id, user_id, format, status, error, created_at, completed_at, expires_at`
+
+func scanDataExport(row interface{ Scan(...interface{}) error }) (*DataExport, error) {
+	var export DataExport
+	err := row.Scan(&export.ID, &export.UserID, &export.Format, &export.Status, &export.Error,
+		&export.CreatedAt, &export.CompletedAt, &export.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// CreateDataExport queues an export job
+func CreateDataExport(userID, format string) (*DataExport, error) {
+	return scanDataExport(config.DB.QueryRow(context.Background(),
+		`INSERT INTO data_exports (user_id, format, status, created_at) VALUES ($1, $2, 'pending', NOW())
+		 RETURNING `+dataExportColumns,
+		userID, format,
+	))
+}
+
+// GetActiveDataExport returns the user's queued or running export, if any
+func GetActiveDataExport(userID string) (*DataExport, error) {
+	return scanDataExport(config.DB.QueryRow(context.Background(),
+		`SELECT `+dataExportColumns+` FROM data_exports
+		 WHERE user_id = $1 AND status IN ('pending', 'running')
+		 ORDER BY created_at DESC LIMIT 1`,
+		userID,
+	))
+}
+
+func GetDataExport(userID, id string) (*DataExport, error) {
+	return scanDataExport(config.DB.QueryRow(context.Background(),
+		`SELECT `+dataExportColumns+` FROM data_exports WHERE id = $1 AND user_id = $2`,
+		id, userID,
+	))
+}
+
+// ClaimDataExport marks the oldest queued export as running and returns it.
+// Exports left running for longer than staleAfter (e.g. by a crashed
+// instance) are picked up again.
+func ClaimDataExport(staleAfter time.Duration) (*DataExport, error) {
+	return scanDataExport(config.DB.QueryRow(context.Background(),
+		`UPDATE data_exports SET status = 'running', started_at = NOW()
+		 WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = 'pending' OR (status = 'running' AND started_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+dataExportColumns,
+		time.Now().Add(-staleAfter),
+	))
+}
+
+// CompleteDataExport stores the archive of a finished export together with
+// the hash of its download token
+func CompleteDataExport(id string, archive []byte, tokenHash string, expiresAt time.Time) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE data_exports SET status = 'completed', archive = $2, token_hash = $3, completed_at = NOW(), expires_at = $4
+		 WHERE id = $1`,
+		id, archive, tokenHash, expiresAt,
+	)
+	return err
+}
+
+// FailDataExport marks an export failed and drops any archive it stored
+func FailDataExport(id, message string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE data_exports SET status = 'failed', error = $2, archive = NULL, token_hash = NULL, completed_at = NOW()
+		 WHERE id = $1`,
+		id, message,
+	)
+	return err
+}
+
+// GetDataExportArchive returns a completed, unexpired export archive matching
+// the download token hash
+func GetDataExportArchive(id, tokenHash string) (*DataExport, []byte, error) {
+	var archive []byte
+	var export DataExport
+	err := config.DB.QueryRow(context.Background(),
+		`SELECT `+dataExportColumns+`, archive FROM data_exports
+		 WHERE id = $1 AND token_hash = $2 AND status = 'completed' AND expires_at > NOW()`,
+		id, tokenHash,
+	).Scan(&export.ID, &export.UserID, &export.Format, &export.Status, &export.Error,
+		&export.CreatedAt, &export.CompletedAt, &export.ExpiresAt, &archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &export, archive, nil
+}
+
+// DeleteExpiredDataExports removes downloadable archives past their expiry
+// and failed jobs older than a day
+func DeleteExpiredDataExports() error {
+	_, err := config.DB.Exec(context.Background(),
+		`DELETE FROM data_exports
+		 WHERE expires_at < NOW() OR (status = 'failed' AND completed_at < NOW() - INTERVAL '1 day')`,
+	)
+	return err
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// isNoRows reports whether a query matched no rows
+func isNoRows(err error) bool {
+	return errors.Is(err, pgx.ErrNoRows)
+}
+
+// IsNotFound reports whether an error from a model lookup means the row does not exist
+func IsNotFound(err error) bool {
+	return isNoRows(err)
+}
+
+// isUniqueViolation reports whether an insert or update hit a unique constraint
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
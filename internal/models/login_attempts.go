@@ -0,0 +1,136 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"time"
+)
+
+// LoginIPState is the failed login counter for one client IP
+type LoginIPState struct {
+	IPAddress       string
+	FailedAttempts  int
+	WindowStartedAt time.Time
+	LastFailedAt    *time.Time
+	BlockedUntil    *time.Time
+}
+
+// RecordUserLoginFailure bumps the user's failed login counter and returns
+// the new count
+func RecordUserLoginFailure(userID string) (int, error) {
+	var attempts int
+	err := config.DB.QueryRow(context.Background(),
+		`UPDATE users SET failed_login_attempts = failed_login_attempts + 1, last_failed_login_at = $2
+		 WHERE id = $1 RETURNING failed_login_attempts`,
+		userID, time.Now(),
+	).Scan(&attempts)
+	return attempts, err
+}
+
+// ClaimUserLoginAttempt counts a login attempt against the user before its
+// credential is checked and returns the new count. The attempt is refused,
+// and ok is false, when the account is locked or already has limit attempts
+// counted, so concurrent attempts cannot go past the limit.
+func ClaimUserLoginAttempt(userID string, limit int) (int, bool, error) {
+	var attempts int
+	now := time.Now()
+	err := config.DB.QueryRow(context.Background(),
+		`UPDATE users SET failed_login_attempts = failed_login_attempts + 1, last_failed_login_at = $3
+		 WHERE id = $1 AND failed_login_attempts < $2 AND (locked_until IS NULL OR locked_until <= $3)
+		 RETURNING failed_login_attempts`,
+		userID, limit, now,
+	).Scan(&attempts)
+	if isNoRows(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return attempts, true, nil
+}
+
+// LockUser locks the account until the given time. The failure counter starts
+// over so backoff applies again once the lock expires. Suspended accounts and
+// accounts pending deletion keep their status.
+func LockUser(userID string, until time.Time) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET locked_until = $2, failed_login_attempts = 0,
+		        status = CASE WHEN status IN ($3, $4) THEN $3 ELSE status END,
+		        status_reason = CASE WHEN status IN ($3, $4) THEN 'Too many failed login attempts' ELSE status_reason END,
+		        status_expires_at = CASE WHEN status IN ($3, $4) THEN $2 ELSE status_expires_at END,
+		        status_changed_at = CASE WHEN status IN ($3, $4) THEN NOW() ELSE status_changed_at END
+		 WHERE id = $1`,
+		userID, until, StatusLocked, StatusActive,
+	)
+	return err
+}
+
+// ResetUserLoginFailures clears the failed login counter and any lock
+func ResetUserLoginFailures(userID string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET failed_login_attempts = 0, last_failed_login_at = NULL, locked_until = NULL,
+		        status = CASE WHEN status = $2 THEN $3 ELSE status END,
+		        status_reason = CASE WHEN status = $2 THEN '' ELSE status_reason END,
+		        status_expires_at = CASE WHEN status = $2 THEN NULL ELSE status_expires_at END,
+		        status_changed_at = CASE WHEN status = $2 THEN NOW() ELSE status_changed_at END
+		 WHERE id = $1`,
+		userID, StatusLocked, StatusActive,
+	)
+	return err
+}
+
+// GetLoginIPState returns the failed login counter for an IP
+func GetLoginIPState(ipAddress string) (*LoginIPState, error) {
+	var state LoginIPState
+	err := config.DB.QueryRow(context.Background(),
+		`SELECT ip_address, failed_attempts, window_started_at, last_failed_at, blocked_until
+		 FROM login_ip_attempts WHERE ip_address = $1`,
+		ipAddress,
+	).Scan(&state.IPAddress, &state.FailedAttempts, &state.WindowStartedAt, &state.LastFailedAt, &state.BlockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// RecordIPLoginFailure counts a failed login from an IP within a fixed
+// window and returns the count. A new window starts once the old one is over.
+func RecordIPLoginFailure(ipAddress string, window time.Duration) (int, error) {
+	now := time.Now()
+	var attempts int
+	err := config.DB.QueryRow(context.Background(),
+		`INSERT INTO login_ip_attempts (ip_address, failed_attempts, window_started_at, last_failed_at)
+		 VALUES ($1, 1, $2, $2)
+		 ON CONFLICT (ip_address) DO UPDATE SET
+			failed_attempts = CASE WHEN login_ip_attempts.window_started_at < $3 THEN 1
+			                       ELSE login_ip_attempts.failed_attempts + 1 END,
+			window_started_at = CASE WHEN login_ip_attempts.window_started_at < $3 THEN $2
+			                         ELSE login_ip_attempts.window_started_at END,
+			last_failed_at = $2
+		 RETURNING failed_attempts`,
+		ipAddress, now, now.Add(-window),
+	).Scan(&attempts)
+	return attempts, err
+}
+
+// BlockIP rejects logins from an IP until the given time
+func BlockIP(ipAddress string, until time.Time) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE login_ip_attempts SET blocked_until = $2, failed_attempts = 0, window_started_at = $3
+		 WHERE ip_address = $1`,
+		ipAddress, until, time.Now(),
+	)
+	return err
+}
+
+// DeleteStaleLoginIPAttempts removes IP counters whose window and block are
+// both over
+func DeleteStaleLoginIPAttempts(window time.Duration) error {
+	now := time.Now()
+	_, err := config.DB.Exec(context.Background(),
+		`DELETE FROM login_ip_attempts
+		 WHERE window_started_at < $1 AND (blocked_until IS NULL OR blocked_until < $2)`,
+		now.Add(-window), now,
+	)
+	return err
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"time"
+)
+
+type UserTOTP struct {
+	UserID       string     `json:"user_id"`
+	Secret       string     `json:"-"`
+	Enabled      bool       `json:"enabled"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+}
+
+// MFAChallenge is the short-lived state between a successful password check
+// and the second factor
+type MFAChallenge struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	TokenHash  string    `json:"-"`
+	DeviceName string    `json:"device_name"`
+	Attempts   int       `json:"attempts"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// SaveTOTPSecret stores a new, not yet confirmed, TOTP secret for a user
+func SaveTOTPSecret(userID, secret string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`INSERT INTO user_totp (user_id, secret, enabled, last_used_step, created_at)
+		 VALUES ($1, $2, false, 0, NOW())
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET secret = $2, enabled = false, last_used_step = 0, created_at = NOW(), confirmed_at = NULL`,
+		userID, secret,
+	)
+	return err
+}
+
+func GetUserTOTP(userID string) (*UserTOTP, error) {
+	var totp UserTOTP
+	err := config.DB.QueryRow(context.Background(),
+		`SELECT user_id, secret, enabled, last_used_step, created_at, confirmed_at
+		 FROM user_totp WHERE user_id = $1`,
+		userID,
+	).Scan(&totp.UserID, &totp.Secret, &totp.Enabled, &totp.LastUsedStep, &totp.CreatedAt, &totp.ConfirmedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// IsTOTPEnabled reports whether the user has confirmed TOTP enrollment
+func IsTOTPEnabled(userID string) (bool, error) {
+	totp, err := GetUserTOTP(userID)
+	if err != nil {
+		if isNoRows(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return totp.Enabled, nil
+}
+
+// UseTOTPStep records the time step of an accepted code. It returns false if
+// an equal or later step was already used, i.e. the code is a replay.
+func UseTOTPStep(userID string, step int64) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`,
+		userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func EnableTOTP(userID string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE user_totp SET enabled = true, confirmed_at = NOW() WHERE user_id = $1`,
+		userID,
+	)
+	return err
+}
+
+// DisableTOTP removes the TOTP secret and all recovery codes
+func DisableTOTP(userID string) error {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ReplaceRecoveryCodes swaps the user's recovery codes for a new set of hashes
+func ReplaceRecoveryCodes(userID string, codeHashes []string) error {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, NOW())`,
+			userID, hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseRecoveryCode marks a recovery code as used. It returns false if the code
+// does not exist or was already used.
+func UseRecoveryCode(userID, codeHash string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`UPDATE mfa_recovery_codes SET used_at = NOW()
+		 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func CountUnusedRecoveryCodes(userID string) (int, error) {
+	var count int
+	err := config.DB.QueryRow(context.Background(),
+		`SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`,
+		userID,
+	).Scan(&count)
+	return count, err
+}
+
+func CreateMFAChallenge(challenge *MFAChallenge) error {
+	return config.DB.QueryRow(context.Background(),
+		`INSERT INTO mfa_challenges (user_id, token_hash, device_name, attempts, expires_at, created_at)
+		 VALUES ($1, $2, $3, 0, $4, NOW())
+		 RETURNING id`,
+		challenge.UserID, challenge.TokenHash, challenge.DeviceName, challenge.ExpiresAt,
+	).Scan(&challenge.ID)
+}
+
+func GetMFAChallengeByHash(tokenHash string) (*MFAChallenge, error) {
+	var challenge MFAChallenge
+	err := config.DB.QueryRow(context.Background(),
+		`SELECT id, user_id, token_hash, COALESCE(device_name, ''), attempts, expires_at
+		 FROM mfa_challenges WHERE token_hash = $1`,
+		tokenHash,
+	).Scan(&challenge.ID, &challenge.UserID, &challenge.TokenHash, &challenge.DeviceName,
+		&challenge.Attempts, &challenge.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// ClaimMFAChallengeAttempt uses up one of the challenge's attempts before a
+// code is checked. Claiming in a single statement keeps concurrent requests
+// from getting past the limit; false means no attempts are left.
+func ClaimMFAChallengeAttempt(id string, maxAttempts int) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1 AND attempts < $2`,
+		id, maxAttempts,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func DeleteMFAChallenge(id string) error {
+	_, err := config.DB.Exec(context.Background(), `DELETE FROM mfa_challenges WHERE id = $1`, id)
+	return err
+}
+
+func DeleteExpiredMFAChallenges() error {
+	_, err := config.DB.Exec(context.Background(), `DELETE FROM mfa_challenges WHERE expires_at < NOW()`)
+	return err
+}
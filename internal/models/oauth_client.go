@@ -0,0 +1,76 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"time"
+)
+
+// OAuthClient is a service that authenticates with client credentials to
+// introspect or revoke tokens
+type OAuthClient struct {
+	ID               string    `json:"id"`
+	ClientID         string    `json:"client_id"`
+	ClientSecretHash string    `json:"-"`
+	Name             string    `json:"name"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func CreateOAuthClient(clientID, clientSecretHash, name string) (*OAuthClient, error) {
+	client := OAuthClient{ClientID: clientID, ClientSecretHash: clientSecretHash, Name: name}
+	err := config.DB.QueryRow(context.Background(),
+		`INSERT INTO oauth_clients (client_id, client_secret_hash, name, created_at)
+		 VALUES ($1, $2, $3, NOW())
+		 RETURNING id, created_at`,
+		clientID, clientSecretHash, name,
+	).Scan(&client.ID, &client.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func GetOAuthClientByClientID(clientID string) (*OAuthClient, error) {
+	var client OAuthClient
+	err := config.DB.QueryRow(context.Background(),
+		`SELECT id, client_id, client_secret_hash, name, created_at
+		 FROM oauth_clients WHERE client_id = $1`,
+		clientID,
+	).Scan(&client.ID, &client.ClientID, &client.ClientSecretHash, &client.Name, &client.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func ListOAuthClients() ([]OAuthClient, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT id, client_id, client_secret_hash, name, created_at
+		 FROM oauth_clients ORDER BY created_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []OAuthClient{}
+	for rows.Next() {
+		var client OAuthClient
+		if err := rows.Scan(&client.ID, &client.ClientID, &client.ClientSecretHash, &client.Name, &client.CreatedAt); err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+func DeleteOAuthClient(clientID string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`DELETE FROM oauth_clients WHERE client_id = $1`,
+		clientID,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
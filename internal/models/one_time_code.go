@@ -0,0 +1,243 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/utils"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Purposes of the built-in one-time codes
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	PurposeLogin         = "login"
+	PurposeChangeEmail   = "change_email"
+	PurposeStepUp        = "step_up"
+	PurposeMagicLink     = "magic_link"
+
+	PurposeCancelEmailChange = "cancel_email_change"
+)
+
+var (
+	ErrCodeNotFound        = errors.New("invalid email or OTP")
+	ErrCodeExpired         = errors.New("OTP has expired")
+	ErrCodeInvalid         = errors.New("invalid OTP")
+	ErrCodeTooManyAttempts = errors.New("too many failed attempts, request a new OTP")
+	ErrCodeRecentlyIssued  = errors.New("an OTP was sent recently, please wait before requesting another")
+	ErrCodeDailyLimit      = errors.New("too many codes requested today, try again tomorrow")
+	ErrUnknownCodePurpose  = errors.New("unknown one-time code purpose")
+)
+
+// CodePurpose describes how codes for one purpose are generated and checked.
+// A zero MaxAttempts or ResendInterval falls back to OTP_MAX_ATTEMPTS and
+// OTP_RESEND_INTERVAL; a zero DailyLimit means no daily cap. Every setting can
+// be overridden per purpose with OTP_<PURPOSE>_<SETTING>, e.g.
+// OTP_VERIFY_EMAIL_DAILY_LIMIT.
+type CodePurpose struct {
+	Name           string
+	Length         int
+	Alphabet       string
+	TTL            time.Duration
+	MaxAttempts    int
+	ResendInterval time.Duration
+	DailyLimit     int
+}
+
+var codePurposes = map[string]CodePurpose{}
+
+func init() {
+	RegisterCodePurpose(CodePurpose{Name: PurposeVerifyEmail, Length: 6, Alphabet: utils.AlphanumericCodeAlphabet, TTL: 15 * time.Minute, DailyLimit: 10})
+	RegisterCodePurpose(CodePurpose{Name: PurposeResetPassword, Length: 6, Alphabet: utils.AlphanumericCodeAlphabet, TTL: 15 * time.Minute, DailyLimit: 10})
+	RegisterCodePurpose(CodePurpose{Name: PurposeLogin, Length: 6, Alphabet: utils.NumericCodeAlphabet, TTL: 10 * time.Minute, DailyLimit: 10})
+	RegisterCodePurpose(CodePurpose{Name: PurposeChangeEmail, Length: 6, Alphabet: utils.NumericCodeAlphabet, TTL: 15 * time.Minute})
+	RegisterCodePurpose(CodePurpose{Name: PurposeStepUp, Length: 6, Alphabet: utils.NumericCodeAlphabet, TTL: 5 * time.Minute, MaxAttempts: 3})
+	// Magic link tokens are long enough that guessing is not a concern
+	RegisterCodePurpose(CodePurpose{Name: PurposeMagicLink, Length: 43, Alphabet: utils.AlphanumericCodeAlphabet, TTL: 10 * time.Minute, DailyLimit: 10})
+	RegisterCodePurpose(CodePurpose{Name: PurposeCancelEmailChange, Length: 43, Alphabet: utils.AlphanumericCodeAlphabet, TTL: 15 * time.Minute})
+}
+
+// RegisterCodePurpose adds or replaces a code purpose. It is meant to be
+// called from init functions, before any code is issued.
+func RegisterCodePurpose(purpose CodePurpose) {
+	codePurposes[purpose.Name] = purpose
+}
+
+func getCodePurpose(name string) (CodePurpose, error) {
+	purpose, ok := codePurposes[name]
+	if !ok {
+		return CodePurpose{}, ErrUnknownCodePurpose
+	}
+	if purpose.MaxAttempts == 0 {
+		purpose.MaxAttempts = config.GetEnvInt("OTP_MAX_ATTEMPTS", 5)
+	}
+	if purpose.ResendInterval == 0 {
+		purpose.ResendInterval = config.GetEnvDuration("OTP_RESEND_INTERVAL", time.Minute)
+	}
+
+	prefix := "OTP_" + strings.ToUpper(purpose.Name) + "_"
+	purpose.Length = config.GetEnvInt(prefix+"LENGTH", purpose.Length)
+	purpose.TTL = config.GetEnvDuration(prefix+"TTL", purpose.TTL)
+	purpose.MaxAttempts = config.GetEnvInt(prefix+"MAX_ATTEMPTS", purpose.MaxAttempts)
+	purpose.ResendInterval = config.GetEnvDuration(prefix+"RESEND_INTERVAL", purpose.ResendInterval)
+	purpose.DailyLimit = config.GetEnvInt(prefix+"DAILY_LIMIT", purpose.DailyLimit)
+	return purpose, nil
+}
+
+// IssueCode generates a code for the purpose and subject, stores its hash
+// together with data and returns the code. Any previous code for the same
+// purpose and subject is replaced. A new code is refused with
+// ErrCodeRecentlyIssued while the last one is younger than the purpose's
+// resend interval, and with ErrCodeDailyLimit once the subject has been sent
+// the purpose's daily limit of codes.
+func IssueCode(purposeName, subject string, data map[string]string) (string, error) {
+	purpose, err := getCodePurpose(purposeName)
+	if err != nil {
+		return "", err
+	}
+
+	if purpose.DailyLimit > 0 {
+		var sent int
+		err := config.DB.QueryRow(context.Background(),
+			`SELECT sent FROM one_time_code_sends WHERE purpose = $1 AND subject = $2 AND day = CURRENT_DATE`,
+			purpose.Name, subject,
+		).Scan(&sent)
+		if err != nil && !isNoRows(err) {
+			return "", err
+		}
+		if sent >= purpose.DailyLimit {
+			return "", ErrCodeDailyLimit
+		}
+	}
+
+	code, err := utils.GenerateCode(purpose.Length, purpose.Alphabet)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	tag, err := config.DB.Exec(context.Background(),
+		`INSERT INTO one_time_codes (purpose, subject, code_hash, data, attempts, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, 0, $5, $6)
+		 ON CONFLICT (purpose, subject) DO UPDATE SET
+			code_hash = $3, data = $4, attempts = 0, expires_at = $5, created_at = $6
+		 WHERE one_time_codes.created_at < $7`,
+		purpose.Name, subject, utils.HashOTP(subject, code), data, now.Add(purpose.TTL), now,
+		now.Add(-purpose.ResendInterval),
+	)
+	if err != nil {
+		return "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return "", ErrCodeRecentlyIssued
+	}
+
+	_, err = config.DB.Exec(context.Background(),
+		`INSERT INTO one_time_code_sends (purpose, subject, day, sent) VALUES ($1, $2, CURRENT_DATE, 1)
+		 ON CONFLICT (purpose, subject, day) DO UPDATE SET sent = one_time_code_sends.sent + 1`,
+		purpose.Name, subject,
+	)
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// VerifyCode checks a code against the stored hash in constant time and
+// consumes it on success, returning the data it was issued with. Every wrong
+// guess counts against the code; once the purpose's attempt limit is reached
+// only a new code can be used.
+func VerifyCode(purposeName, subject, code string) (map[string]string, error) {
+	purpose, err := getCodePurpose(purposeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var codeHash string
+	var data map[string]string
+	var attempts int
+	var expiresAt time.Time
+
+	err = config.DB.QueryRow(context.Background(),
+		`SELECT code_hash, data, attempts, expires_at FROM one_time_codes WHERE purpose = $1 AND subject = $2`,
+		purpose.Name, subject,
+	).Scan(&codeHash, &data, &attempts, &expiresAt)
+	if err != nil {
+		if isNoRows(err) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, err
+	}
+
+	if attempts >= purpose.MaxAttempts {
+		return nil, ErrCodeTooManyAttempts
+	}
+
+	if time.Now().After(expiresAt) {
+		return nil, ErrCodeExpired
+	}
+
+	hash := utils.HashOTP(subject, code)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(codeHash)) != 1 {
+		err := config.DB.QueryRow(context.Background(),
+			`UPDATE one_time_codes SET attempts = attempts + 1 WHERE purpose = $1 AND subject = $2 RETURNING attempts`,
+			purpose.Name, subject,
+		).Scan(&attempts)
+		if err != nil && !isNoRows(err) {
+			return nil, err
+		}
+		if attempts >= purpose.MaxAttempts {
+			return nil, ErrCodeTooManyAttempts
+		}
+		return nil, ErrCodeInvalid
+	}
+
+	// Matching on the hash keeps a concurrent request from consuming the
+	// same code twice
+	tag, err := config.DB.Exec(context.Background(),
+		`DELETE FROM one_time_codes WHERE purpose = $1 AND subject = $2 AND code_hash = $3 AND attempts < $4`,
+		purpose.Name, subject, hash, purpose.MaxAttempts,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrCodeNotFound
+	}
+	return data, nil
+}
+
+// DeleteCode discards the pending code for a purpose and subject
+func DeleteCode(purposeName, subject string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`DELETE FROM one_time_codes WHERE purpose = $1 AND subject = $2`,
+		purposeName, subject,
+	)
+	return err
+}
+
+// DeleteExpiredCodes removes expired codes and send counters of past days
+func DeleteExpiredCodes() error {
+	_, err := config.DB.Exec(context.Background(), `DELETE FROM one_time_codes WHERE expires_at < NOW()`)
+	if err != nil {
+		return err
+	}
+	_, err = config.DB.Exec(context.Background(), `DELETE FROM one_time_code_sends WHERE day < CURRENT_DATE`)
+	return err
+}
+
+// VerifyUserOTP consumes an email verification code and marks the user verified
+func VerifyUserOTP(email, otp string) error {
+	if _, err := VerifyCode(PurposeVerifyEmail, email, otp); err != nil {
+		return err
+	}
+
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET is_verified = true WHERE email = $1`,
+		email,
+	)
+	return err
+}
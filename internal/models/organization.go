@@ -0,0 +1,393 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Organization roles, from most to least privileged
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+var (
+	ErrOrganizationSlugTaken = errors.New("organization slug already taken")
+	ErrAlreadyMember         = errors.New("user is already a member of this organization")
+	ErrLastOwner             = errors.New("an organization must keep at least one owner")
+)
+
+// OrgRoleRank orders organization roles; higher ranks include the lower
+// ones. Unknown roles rank 0.
+func OrgRoleRank(role string) int {
+	switch role {
+	case OrgRoleOwner:
+		return 3
+	case OrgRoleAdmin:
+		return 2
+	case OrgRoleMember:
+		return 1
+	}
+	return 0
+}
+
+// Organization is a tenant that users belong to
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership is a user's membership of an organization
+type Membership struct {
+	Organization Organization `json:"organization"`
+	UserID       string       `json:"user_id"`
+	Role         string       `json:"role"`
+	JoinedAt     time.Time    `json:"joined_at"`
+}
+
+// OrganizationMember is a member as listed to the organization's admins
+type OrganizationMember struct {
+	UserID      string    `json:"user_id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+// OrganizationInvitation invites an email address to join an organization.
+// Only a hash of its token is stored.
+type OrganizationInvitation struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organization_id"`
+	Email          string    `json:"email"`
+	Role           string    `json:"role"`
+	InvitedBy      *string   `json:"invited_by"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// CreateOrganization creates an organization with the user as its owner
+func CreateOrganization(userID, name, slug string) (*Membership, error) {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	membership := Membership{UserID: userID, Role: OrgRoleOwner}
+	org := &membership.Organization
+	err = tx.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug, created_by, created_at) VALUES ($1, $2, $3, NOW())
+		 RETURNING id, name, slug, created_at`,
+		name, slug, userID,
+	).Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrOrganizationSlugTaken
+		}
+		return nil, err
+	}
+
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO organization_members (organization_id, user_id, role, created_at)
+		 VALUES ($1, $2, $3, NOW())
+		 RETURNING created_at`,
+		org.ID, userID, OrgRoleOwner,
+	).Scan(&membership.JoinedAt); err != nil {
+		return nil, err
+	}
+
+	return &membership, tx.Commit(ctx)
+}
+
+// ListMemberships returns the organizations a user belongs to, oldest
+// membership first
+func ListMemberships(userID string) ([]Membership, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT o.id, o.name, o.slug, o.created_at, m.user_id, m.role, m.created_at
+		 FROM organization_members m JOIN organizations o ON o.id = m.organization_id
+		 WHERE m.user_id = $1
+		 ORDER BY m.created_at, o.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []Membership{}
+	for rows.Next() {
+		membership, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, *membership)
+	}
+	return memberships, rows.Err()
+}
+
+// GetMembership returns a user's membership of an organization. It returns
+// pgx.ErrNoRows when the user is not a member.
+func GetMembership(organizationID, userID string) (*Membership, error) {
+	return scanMembership(config.DB.QueryRow(context.Background(),
+		`SELECT o.id, o.name, o.slug, o.created_at, m.user_id, m.role, m.created_at
+		 FROM organization_members m JOIN organizations o ON o.id = m.organization_id
+		 WHERE m.organization_id = $1 AND m.user_id = $2`,
+		organizationID, userID,
+	))
+}
+
+// GetActiveMembership returns the membership a session should work in: the
+// one for organizationID while the user is still a member, otherwise their
+// oldest membership. It returns nil when the user has no organization.
+func GetActiveMembership(userID string, organizationID *string) (*Membership, error) {
+	if organizationID != nil {
+		membership, err := GetMembership(*organizationID, userID)
+		if err == nil {
+			return membership, nil
+		}
+		if !isNoRows(err) {
+			return nil, err
+		}
+	}
+
+	memberships, err := ListMemberships(userID)
+	if err != nil || len(memberships) == 0 {
+		return nil, err
+	}
+	return &memberships[0], nil
+}
+
+func scanMembership(row pgx.Row) (*Membership, error) {
+	var membership Membership
+	org := &membership.Organization
+	if err := row.Scan(&org.ID, &org.Name, &org.Slug, &org.CreatedAt,
+		&membership.UserID, &membership.Role, &membership.JoinedAt); err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+// ListOrganizationMembers returns the members of an organization by email
+func ListOrganizationMembers(organizationID string) ([]OrganizationMember, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT u.id, u.email, u.display_name, m.role, m.created_at
+		 FROM organization_members m JOIN users u ON u.id = m.user_id
+		 WHERE m.organization_id = $1
+		 ORDER BY u.email`,
+		organizationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrganizationMember{}
+	for rows.Next() {
+		var member OrganizationMember
+		if err := rows.Scan(&member.UserID, &member.Email, &member.DisplayName, &member.Role, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// UpdateMemberRole changes a member's role. It returns false when the user is
+// not a member, and ErrLastOwner when it would demote the last owner.
+func UpdateMemberRole(organizationID, userID, role string) (bool, error) {
+	return changeMembership(organizationID, userID, &role)
+}
+
+// RemoveMember removes a user from an organization. It returns false when the
+// user is not a member, and ErrLastOwner when they are the last owner.
+func RemoveMember(organizationID, userID string) (bool, error) {
+	return changeMembership(organizationID, userID, nil)
+}
+
+// changeMembership gives a member a new role, or removes them when role is
+// nil, refusing to leave the organization without an owner. The owners are
+// locked so two concurrent changes cannot each remove one of the last two.
+func changeMembership(organizationID, userID string, role *string) (bool, error) {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT user_id FROM organization_members
+		 WHERE organization_id = $1 AND role = $2
+		 FOR UPDATE`,
+		organizationID, OrgRoleOwner,
+	)
+	if err != nil {
+		return false, err
+	}
+	owners := map[string]bool{}
+	for rows.Next() {
+		var owner string
+		if err := rows.Scan(&owner); err != nil {
+			rows.Close()
+			return false, err
+		}
+		owners[owner] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	if owners[userID] && len(owners) == 1 && (role == nil || *role != OrgRoleOwner) {
+		return false, ErrLastOwner
+	}
+
+	var tag pgconn.CommandTag
+	if role == nil {
+		tag, err = tx.Exec(ctx,
+			`DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`,
+			organizationID, userID,
+		)
+	} else {
+		tag, err = tx.Exec(ctx,
+			`UPDATE organization_members SET role = $3 WHERE organization_id = $1 AND user_id = $2`,
+			organizationID, userID, *role,
+		)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// CreateOrganizationInvitation stores an invitation, replacing any pending
+// invitation of the same email address to the organization
+func CreateOrganizationInvitation(invitation *OrganizationInvitation, tokenHash string) error {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM organization_invitations WHERE organization_id = $1 AND LOWER(email) = LOWER($2)`,
+		invitation.OrganizationID, invitation.Email,
+	); err != nil {
+		return err
+	}
+	if err := tx.QueryRow(ctx,
+		`INSERT INTO organization_invitations (organization_id, email, role, token_hash, invited_by, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		 RETURNING id, created_at`,
+		invitation.OrganizationID, invitation.Email, invitation.Role, tokenHash,
+		invitation.InvitedBy, invitation.ExpiresAt,
+	).Scan(&invitation.ID, &invitation.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ListOrganizationInvitations returns the pending invitations of an
+// organization, newest first
+func ListOrganizationInvitations(organizationID string) ([]OrganizationInvitation, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT id, organization_id, email, role, invited_by, expires_at, created_at
+		 FROM organization_invitations
+		 WHERE organization_id = $1 AND expires_at > NOW()
+		 ORDER BY created_at DESC`,
+		organizationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []OrganizationInvitation{}
+	for rows.Next() {
+		var invitation OrganizationInvitation
+		if err := rows.Scan(&invitation.ID, &invitation.OrganizationID, &invitation.Email, &invitation.Role,
+			&invitation.InvitedBy, &invitation.ExpiresAt, &invitation.CreatedAt); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+// DeleteOrganizationInvitation withdraws a pending invitation. It returns
+// false when the organization has no such invitation.
+func DeleteOrganizationInvitation(organizationID, invitationID string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`DELETE FROM organization_invitations WHERE id = $1 AND organization_id = $2`,
+		invitationID, organizationID,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// AcceptOrganizationInvitation redeems an unexpired invitation addressed to
+// email, making the user a member with the invited role. The invitation is
+// used up either way. It returns pgx.ErrNoRows when there is no such
+// invitation, and ErrAlreadyMember when the user already belongs to the
+// organization.
+func AcceptOrganizationInvitation(tokenHash, userID, email string) (*Membership, error) {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var organizationID, role string
+	err = tx.QueryRow(ctx,
+		`DELETE FROM organization_invitations
+		 WHERE token_hash = $1 AND LOWER(email) = LOWER($2) AND expires_at > NOW()
+		 RETURNING organization_id, role`,
+		tokenHash, email,
+	).Scan(&organizationID, &role)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO organization_members (organization_id, user_id, role, created_at)
+		 VALUES ($1, $2, $3, NOW())
+		 ON CONFLICT DO NOTHING`,
+		organizationID, userID, role,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrAlreadyMember
+	}
+
+	return GetMembership(organizationID, userID)
+}
+
+// DeleteExpiredOrganizationInvitations removes invitations past their expiry
+func DeleteExpiredOrganizationInvitations() error {
+	_, err := config.DB.Exec(context.Background(),
+		`DELETE FROM organization_invitations WHERE expires_at < NOW()`,
+	)
+	return err
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// OutboxEvent is an event waiting in event_outbox to be published. Writing
+// it in the transaction of the change it announces means the event cannot
+// get lost when the broker is down: it stays until it has been published.
+type OutboxEvent struct {
+	ID         int64
+	Type       string
+	UserID     string
+	Data       map[string]interface{}
+	OccurredAt time.Time
+	Attempts   int
+}
+
+// enqueueEvent adds an event to the outbox as part of tx
+func enqueueEvent(ctx context.Context, tx pgx.Tx, eventType, userID string, data map[string]interface{}) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO event_outbox (event_type, user_id, data, occurred_at) VALUES ($1, $2, $3, NOW())`,
+		eventType, userID, data,
+	)
+	return err
+}
+
+// PublishOutboxEvents hands pending events to publish, oldest first, and
+// removes each one once it has been published. It stops at the first
+// failure so events stay in order; that event is retried on the next run.
+// Rows are locked while they are published, so instances do not publish
+// the same event twice.
+func PublishOutboxEvents(limit int, publish func(*OutboxEvent) error) (int, error) {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT id, event_type, COALESCE(user_id::text, ''), data, occurred_at, attempts
+		 FROM event_outbox ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`,
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var events []OutboxEvent
+	for rows.Next() {
+		var event OutboxEvent
+		if err := rows.Scan(&event.ID, &event.Type, &event.UserID, &event.Data, &event.OccurredAt, &event.Attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	var publishErr error
+	for i := range events {
+		if publishErr = publish(&events[i]); publishErr != nil {
+			if _, err := tx.Exec(ctx,
+				`UPDATE event_outbox SET attempts = attempts + 1, last_error = $2 WHERE id = $1`,
+				events[i].ID, publishErr.Error(),
+			); err != nil {
+				return 0, err
+			}
+			break
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM event_outbox WHERE id = $1`, events[i].ID); err != nil {
+			return 0, err
+		}
+		published++
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return published, publishErr
+}
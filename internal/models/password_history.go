@@ -0,0 +1,68 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+)
+
+// ChangeUserPassword replaces the user's password hash and moves the old one
+// into the password history, keeping only the newest keep entries. With keep
+// at zero no history is kept.
+func ChangeUserPassword(userID, passwordHash string, keep int) error {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if keep > 0 {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO password_history (user_id, password_hash, created_at)
+			 SELECT id, password_hash, NOW() FROM users WHERE id = $1`,
+			userID,
+		); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET password_hash = $2, password_reset_required = false WHERE id = $1`,
+		userID, passwordHash,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM password_history WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2
+		 )`,
+		userID, keep,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ListPasswordHistory returns the user's previous password hashes, newest first
+func ListPasswordHistory(userID string, limit int) ([]string, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT password_hash FROM password_history WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrProfileModified = errors.New("profile was modified by another request")
+
+// ProfileUpdate holds the profile fields to change. Nil fields are left as
+// they are; an empty string clears the field.
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name"`
+	GivenName   *string `json:"given_name"`
+	FamilyName  *string `json:"family_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Locale      *string `json:"locale"`
+	Timezone    *string `json:"timezone"`
+}
+
+// UpdateUserProfile applies a partial profile update and returns the updated
+// user. When ifUpdatedAt is set the update only happens if the profile was
+// not modified since then; otherwise ErrProfileModified is returned.
+func UpdateUserProfile(userID string, update ProfileUpdate, ifUpdatedAt *time.Time) (*User, error) {
+	user, err := scanUser(config.DB.QueryRow(context.Background(),
+		`UPDATE users SET
+			display_name = COALESCE($2, display_name),
+			given_name = COALESCE($3, given_name),
+			family_name = COALESCE($4, family_name),
+			avatar_url = COALESCE($5, avatar_url),
+			locale = COALESCE($6, locale),
+			timezone = COALESCE($7, timezone),
+			updated_at = NOW()
+		 WHERE id = $1 AND ($8::timestamp IS NULL OR updated_at = $8)
+		 RETURNING `+userColumns,
+		userID, update.DisplayName, update.GivenName, update.FamilyName,
+		update.AvatarURL, update.Locale, update.Timezone, ifUpdatedAt,
+	))
+	if isNoRows(err) && ifUpdatedAt != nil {
+		if _, lookupErr := GetUserByID(userID); lookupErr == nil {
+			return nil, ErrProfileModified
+		}
+	}
+	return user, err
+}
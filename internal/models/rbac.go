@@ -0,0 +1,469 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Built-in roles, which cannot be deleted
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var (
+	ErrRoleExists       = errors.New("role already exists")
+	ErrPermissionExists = errors.New("permission already exists")
+	ErrBuiltInRole      = errors.New("built-in roles cannot be deleted")
+)
+
+// Role is a named set of permissions that can be assigned to users
+type Role struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Permission is an action such as "users:write" that roles grant
+type Permission struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ListRoles returns every role with the permissions it grants
+func ListRoles() ([]Role, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT r.id, r.name, r.description, r.created_at,
+		        COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		 FROM roles r
+		 LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		 LEFT JOIN permissions p ON p.id = rp.permission_id
+		 GROUP BY r.id
+		 ORDER BY r.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.CreatedAt, &role.Permissions); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// CreateRole adds a role without permissions. It returns ErrRoleExists when
+// the name is taken.
+func CreateRole(name, description string) (*Role, error) {
+	role := Role{Name: name, Description: description, Permissions: []string{}}
+	err := config.DB.QueryRow(context.Background(),
+		`INSERT INTO roles (name, description, created_at) VALUES ($1, $2, NOW())
+		 RETURNING id, created_at`,
+		name, description,
+	).Scan(&role.ID, &role.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrRoleExists
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+// DeleteRole removes a role, taking it away from every user that has it
+func DeleteRole(name string) (bool, error) {
+	// Roles of the hierarchy are referenced by configuration and routes
+	if name == RoleUser || name == RoleAdmin || config.IsHierarchyRole(name) {
+		return false, ErrBuiltInRole
+	}
+
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM roles WHERE name = $1`, name)
+	if err != nil {
+		return false, err
+	}
+	// user_roles rows go with the role; the primary role has to be reset
+	// as RemoveRole does
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET role = $2, updated_at = NOW() WHERE role = $1`,
+		name, RoleUser,
+	); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+
+	evictPermissions(nil)
+	return tag.RowsAffected() > 0, nil
+}
+
+func ListPermissions() ([]Permission, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT id, name, description, created_at FROM permissions ORDER BY name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []Permission{}
+	for rows.Next() {
+		var permission Permission
+		if err := rows.Scan(&permission.ID, &permission.Name, &permission.Description, &permission.CreatedAt); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// CreatePermission adds a permission. It returns ErrPermissionExists when the
+// name is taken.
+func CreatePermission(name, description string) (*Permission, error) {
+	permission := Permission{Name: name, Description: description}
+	err := config.DB.QueryRow(context.Background(),
+		`INSERT INTO permissions (name, description, created_at) VALUES ($1, $2, NOW())
+		 RETURNING id, created_at`,
+		name, description,
+	).Scan(&permission.ID, &permission.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrPermissionExists
+		}
+		return nil, err
+	}
+	return &permission, nil
+}
+
+// DeletePermission removes a permission and every grant of it
+func DeletePermission(name string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(), `DELETE FROM permissions WHERE name = $1`, name)
+	if err != nil {
+		return false, err
+	}
+	evictPermissions(nil)
+	return tag.RowsAffected() > 0, nil
+}
+
+// GrantPermission grants a permission to a role. It returns false when the
+// role or permission does not exist.
+func GrantPermission(roleName, permissionName string) (bool, error) {
+	var found bool
+	err := config.DB.QueryRow(context.Background(),
+		`WITH pair AS (
+			SELECT r.id AS role_id, p.id AS permission_id FROM roles r, permissions p
+			WHERE r.name = $1 AND p.name = $2
+		 ), granted AS (
+			INSERT INTO role_permissions (role_id, permission_id)
+			SELECT role_id, permission_id FROM pair
+			ON CONFLICT DO NOTHING
+		 )
+		 SELECT EXISTS (SELECT 1 FROM pair)`,
+		roleName, permissionName,
+	).Scan(&found)
+	if err != nil {
+		return false, err
+	}
+	evictPermissions(nil)
+	return found, nil
+}
+
+// RevokePermission takes a permission away from a role. It returns false when
+// the role did not have it.
+func RevokePermission(roleName, permissionName string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`DELETE FROM role_permissions rp USING roles r, permissions p
+		 WHERE rp.role_id = r.id AND rp.permission_id = p.id AND r.name = $1 AND p.name = $2`,
+		roleName, permissionName,
+	)
+	if err != nil {
+		return false, err
+	}
+	evictPermissions(nil)
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListUserIDsWithRole returns the users a role applies to: those it is
+// assigned to and those holding a role above it in the hierarchy
+func ListUserIDsWithRole(roleName string) ([]string, error) {
+	return listUserIDsWithRoles(config.RolesAbove(roleName))
+}
+
+// ListUserIDsWithPermission returns the users granted a permission through
+// any of their roles or the roles below them
+func ListUserIDsWithPermission(permissionName string) ([]string, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT r.name FROM roles r
+		 JOIN role_permissions rp ON rp.role_id = r.id
+		 JOIN permissions p ON p.id = rp.permission_id
+		 WHERE p.name = $1`,
+		permissionName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, config.RolesAbove(role)...)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	return listUserIDsWithRoles(roles)
+}
+
+func listUserIDsWithRoles(roleNames []string) ([]string, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT DISTINCT ur.user_id FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		 WHERE r.name = ANY($1)`,
+		roleNames,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// GetUserRoles returns the names of the roles assigned to a user
+func GetUserRoles(userID string) ([]string, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		 WHERE ur.user_id = $1 ORDER BY r.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// AssignRole gives a user a role. It returns false when the role does not
+// exist.
+func AssignRole(userID, roleName string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`INSERT INTO user_roles (user_id, role_id, created_at)
+		 SELECT $1, id, NOW() FROM roles WHERE name = $2
+		 ON CONFLICT DO NOTHING`,
+		userID, roleName,
+	)
+	if err != nil {
+		return false, err
+	}
+	evictPermissions(&userID)
+	if tag.RowsAffected() > 0 {
+		return true, nil
+	}
+
+	var exists bool
+	err = config.DB.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, roleName,
+	).Scan(&exists)
+	return exists, err
+}
+
+// RemoveRole takes a role away from a user. When it was the user's primary
+// role (users.role), the primary role falls back to "user". It returns false
+// when the user did not have the role.
+func RemoveRole(userID, roleName string) (bool, error) {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`DELETE FROM user_roles ur USING roles r
+		 WHERE ur.role_id = r.id AND ur.user_id = $1 AND r.name = $2`,
+		userID, roleName,
+	)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET role = $3 WHERE id = $1 AND role = $2`,
+		userID, roleName, RoleUser,
+	); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+
+	evictPermissions(&userID)
+	return tag.RowsAffected() > 0, nil
+}
+
+// Permission lookups are cached in-process for PERMISSION_CACHE_TTL. Changes
+// made by this instance evict the cache immediately, other instances see them
+// once their cached entry expires.
+
+type cachedPermissions struct {
+	permissions []string
+	checkedAt   time.Time
+}
+
+var permissionCache = struct {
+	sync.RWMutex
+	entries map[string]cachedPermissions
+}{entries: map[string]cachedPermissions{}}
+
+// GetUserPermissions returns every permission granted to a user through
+// their roles and the roles below them in the hierarchy, including the
+// patterns configured in ROLE_PERMISSIONS (see config.RolePermissions)
+func GetUserPermissions(userID string) ([]string, error) {
+	ttl := config.GetEnvDuration("PERMISSION_CACHE_TTL", time.Minute)
+
+	permissionCache.RLock()
+	entry, ok := permissionCache.entries[userID]
+	permissionCache.RUnlock()
+
+	if ok && time.Since(entry.checkedAt) < ttl {
+		return entry.permissions, nil
+	}
+	return LoadUserPermissions(userID)
+}
+
+// LoadUserPermissions is GetUserPermissions without the cache, for minting
+// tokens: a permission revoked on another instance may still be cached here,
+// and must not be written into a new access token.
+func LoadUserPermissions(userID string) ([]string, error) {
+	now := time.Now()
+
+	roles, err := GetUserRoles(userID)
+	if err != nil {
+		return nil, err
+	}
+	effective := EffectiveRoles(roles)
+
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT DISTINCT p.name FROM roles r
+		 JOIN role_permissions rp ON rp.role_id = r.id
+		 JOIN permissions p ON p.id = rp.permission_id
+		 WHERE r.name = ANY($1)
+		 ORDER BY p.name`,
+		effective,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		seen[permission] = true
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, role := range effective {
+		for _, pattern := range config.RolePermissions(role) {
+			if !seen[pattern] {
+				seen[pattern] = true
+				permissions = append(permissions, pattern)
+			}
+		}
+	}
+
+	permissionCache.Lock()
+	permissionCache.entries[userID] = cachedPermissions{permissions: permissions, checkedAt: now}
+	permissionCache.Unlock()
+
+	return permissions, nil
+}
+
+// EffectiveRoles expands roles with every role below them in the hierarchy
+func EffectiveRoles(roles []string) []string {
+	effective := []string{}
+	seen := map[string]bool{}
+	for _, role := range roles {
+		for _, implied := range config.ImpliedRoles(role) {
+			if !seen[implied] {
+				seen[implied] = true
+				effective = append(effective, implied)
+			}
+		}
+	}
+	return effective
+}
+
+// evictPermissions drops the cached permissions of one user, or of everyone
+// when userID is nil
+func evictPermissions(userID *string) {
+	permissionCache.Lock()
+	defer permissionCache.Unlock()
+
+	if userID == nil {
+		permissionCache.entries = map[string]cachedPermissions{}
+		return
+	}
+	delete(permissionCache.entries, *userID)
+}
+
+// PrunePermissionCache drops cache entries that are past their TTL
+func PrunePermissionCache() {
+	cutoff := time.Now().Add(-config.GetEnvDuration("PERMISSION_CACHE_TTL", time.Minute))
+
+	permissionCache.Lock()
+	defer permissionCache.Unlock()
+
+	for userID, entry := range permissionCache.entries {
+		if entry.checkedAt.Before(cutoff) {
+			delete(permissionCache.entries, userID)
+		}
+	}
+}
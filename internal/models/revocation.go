@@ -0,0 +1,108 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"sync"
+	"time"
+)
+
+// Access tokens are only valid while their hash is stored in access_tokens.
+// Lookups are cached in-process for ACCESS_TOKEN_CACHE_TTL; revocations made by
+// this instance evict the cache immediately, other instances see them once
+// their cached entry expires.
+
+type cachedAccessToken struct {
+	userID    string
+	sessionID string
+	expiresAt time.Time
+	checkedAt time.Time
+}
+
+var accessTokenCache = struct {
+	sync.RWMutex
+	entries map[string]cachedAccessToken
+}{entries: map[string]cachedAccessToken{}}
+
+// IsAccessTokenActive reports whether an access token hash has not been revoked
+func IsAccessTokenActive(tokenHash string) (bool, error) {
+	now := time.Now()
+	ttl := config.GetEnvDuration("ACCESS_TOKEN_CACHE_TTL", 30*time.Second)
+
+	accessTokenCache.RLock()
+	entry, ok := accessTokenCache.entries[tokenHash]
+	accessTokenCache.RUnlock()
+
+	if ok && now.Before(entry.expiresAt) && now.Sub(entry.checkedAt) < ttl {
+		return true, nil
+	}
+
+	token, err := GetAccessTokenByHash(tokenHash)
+	if err != nil {
+		if isNoRows(err) {
+			evictAccessToken(tokenHash)
+			return false, nil
+		}
+		return false, err
+	}
+
+	if now.After(token.ExpiresAt) {
+		return false, nil
+	}
+
+	sessionID := ""
+	if token.SessionID != nil {
+		sessionID = *token.SessionID
+	}
+
+	accessTokenCache.Lock()
+	accessTokenCache.entries[tokenHash] = cachedAccessToken{
+		userID:    token.UserID,
+		sessionID: sessionID,
+		expiresAt: token.ExpiresAt,
+		checkedAt: now,
+	}
+	accessTokenCache.Unlock()
+
+	return true, nil
+}
+
+// RevokeAccessToken revokes a single access token
+func RevokeAccessToken(tokenHash string) error {
+	evictAccessToken(tokenHash)
+	return DeleteAccessToken(tokenHash)
+}
+
+// RevokeAllAccessTokensForUser revokes every access token issued to a user
+func RevokeAllAccessTokensForUser(userID string) error {
+	evictAccessTokens(func(_ string, entry cachedAccessToken) bool { return entry.userID == userID })
+	return DeleteAllAccessTokensForUser(userID)
+}
+
+// RevokeAccessTokensForSession revokes the access tokens of one login session
+func RevokeAccessTokensForSession(sessionID string) error {
+	evictAccessTokens(func(_ string, entry cachedAccessToken) bool { return entry.sessionID == sessionID })
+	return DeleteAccessTokensForSession(sessionID)
+}
+
+// PruneAccessTokenCache drops cache entries for tokens that have expired
+func PruneAccessTokenCache() {
+	now := time.Now()
+	evictAccessTokens(func(_ string, entry cachedAccessToken) bool { return now.After(entry.expiresAt) })
+}
+
+func evictAccessToken(tokenHash string) {
+	accessTokenCache.Lock()
+	delete(accessTokenCache.entries, tokenHash)
+	accessTokenCache.Unlock()
+}
+
+func evictAccessTokens(match func(hash string, entry cachedAccessToken) bool) {
+	accessTokenCache.Lock()
+	defer accessTokenCache.Unlock()
+
+	for hash, entry := range accessTokenCache.entries {
+		if match(hash, entry) {
+			delete(accessTokenCache.entries, hash)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"time"
+)
+
+// Session is a login session, i.e. a refresh token family. Its metadata comes
+// from the family's newest refresh token.
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	DeviceName string     `json:"device_name"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+}
+
+// ListSessionsForUser returns the user's active sessions, most recently used first
+func ListSessionsForUser(userID string) ([]Session, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT * FROM (
+			SELECT DISTINCT ON (family_id)
+			       family_id, user_id, COALESCE(device_name, ''), COALESCE(user_agent, ''),
+			       COALESCE(ip_address, ''), MIN(created_at) OVER (PARTITION BY family_id),
+			       last_used_at, expires_at
+			FROM refresh_tokens
+			WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+			ORDER BY family_id, created_at DESC
+		 ) sessions
+		 ORDER BY last_used_at DESC NULLS LAST`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.DeviceName, &session.UserAgent,
+			&session.IPAddress, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes one of the user's sessions. It returns false when the
+// session does not exist or belongs to someone else.
+func RevokeSession(userID, sessionID string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`UPDATE refresh_tokens SET revoked_at = NOW()
+		 WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		sessionID, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	return true, RevokeAccessTokensForSession(sessionID)
+}
+
+// RevokeOtherSessions revokes every session of the user except keepSessionID
+func RevokeOtherSessions(userID, keepSessionID string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE refresh_tokens SET revoked_at = NOW()
+		 WHERE user_id = $1 AND family_id IS DISTINCT FROM NULLIF($2, '')::uuid AND revoked_at IS NULL`,
+		userID, keepSessionID,
+	)
+	if err != nil {
+		return err
+	}
+
+	evictAccessTokens(func(_ string, entry cachedAccessToken) bool {
+		return entry.userID == userID && entry.sessionID != keepSessionID
+	})
+	_, err = config.DB.Exec(context.Background(),
+		`DELETE FROM access_tokens WHERE user_id = $1 AND session_id IS DISTINCT FROM NULLIF($2, '')::uuid`,
+		userID, keepSessionID,
+	)
+	return err
+}
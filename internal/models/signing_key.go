@@ -0,0 +1,181 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/utils"
+	"context"
+	"log"
+	"time"
+)
+
+// signingKeyLockID serializes key rotation across instances
+const signingKeyLockID = 7001
+
+type StoredSigningKey struct {
+	ID          string     `json:"id"`
+	Algorithm   string     `json:"algorithm"`
+	PrivateKey  string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatesAt time.Time  `json:"activates_at"`
+	RetiredAt   *time.Time `json:"retired_at"`
+	VerifyUntil *time.Time `json:"verify_until"`
+}
+
+func GetSigningKeys() ([]StoredSigningKey, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT id, algorithm, private_key, created_at, COALESCE(activates_at, created_at), retired_at, verify_until
+		 FROM signing_keys
+		 WHERE verify_until IS NULL OR verify_until > NOW()
+		 ORDER BY created_at DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []StoredSigningKey
+	for rows.Next() {
+		var key StoredSigningKey
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt, &key.ActivatesAt, &key.RetiredAt, &key.VerifyUntil); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func DeleteExpiredSigningKeys() error {
+	query := `DELETE FROM signing_keys WHERE verify_until < NOW()`
+	_, err := config.DB.Exec(context.Background(), query)
+	return err
+}
+
+// LoadSigningKeys reads the key ring from the database into utils
+func LoadSigningKeys() error {
+	stored, err := GetSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*utils.SigningKey, 0, len(stored))
+	for _, s := range stored {
+		priv, err := utils.DecodePrivateKey(s.PrivateKey)
+		if err != nil {
+			log.Printf("Skipping unreadable signing key %s: %v", s.ID, err)
+			continue
+		}
+		keys = append(keys, &utils.SigningKey{
+			ID:          s.ID,
+			Algorithm:   s.Algorithm,
+			PrivateKey:  priv,
+			CreatedAt:   s.CreatedAt,
+			ActivatesAt: s.ActivatesAt,
+			RetiredAt:   s.RetiredAt,
+			VerifyUntil: s.VerifyUntil,
+		})
+	}
+
+	utils.SetSigningKeys(keys)
+	return nil
+}
+
+// RotateSigningKeys creates a new signing key when there is none for the
+// configured algorithm or the current one is older than
+// JWT_KEY_ROTATION_INTERVAL. The new key is published right away but only
+// signs after JWT_KEY_PUBLISH_LEAD, long enough for other instances to
+// reload the key ring and for JWKS consumers to refresh their cache. Once it
+// signs, older keys are retired but stay available for verification for
+// JWT_KEY_VERIFY_WINDOW, which is never shorter than the refresh token
+// lifetime. The key ring is reloaded afterwards.
+func RotateSigningKeys() error {
+	if utils.UsesSharedSecret() {
+		return nil
+	}
+
+	alg := utils.SigningAlgorithm()
+	rotationInterval := config.GetEnvDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour)
+	publishLead := config.GetEnvDuration("JWT_KEY_PUBLISH_LEAD", 10*time.Minute)
+	verifyWindow := config.GetEnvDuration("JWT_KEY_VERIFY_WINDOW", utils.RefreshTokenTTL)
+	if verifyWindow < utils.RefreshTokenTTL {
+		verifyWindow = utils.RefreshTokenTTL
+	}
+
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, signingKeyLockID); err != nil {
+		return err
+	}
+
+	// The newest key that is not retired, which may still be waiting to
+	// sign
+	var algorithm string
+	var activatesAt time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT algorithm, COALESCE(activates_at, created_at) FROM signing_keys
+		 WHERE retired_at IS NULL
+		 ORDER BY COALESCE(activates_at, created_at) DESC LIMIT 1`,
+	).Scan(&algorithm, &activatesAt)
+	if err != nil && !isNoRows(err) {
+		return err
+	}
+
+	first := isNoRows(err)
+	pending := !first && activatesAt.After(time.Now())
+	due := first || (!pending && (algorithm != alg || time.Since(activatesAt) > rotationInterval))
+
+	if due {
+		key, err := utils.GenerateSigningKey(alg)
+		if err != nil {
+			return err
+		}
+
+		encoded, err := utils.EncodePrivateKey(key.PrivateKey)
+		if err != nil {
+			return err
+		}
+
+		// Nothing can have signed with a key before there was one, so the
+		// first key signs right away
+		lead := publishLead
+		if first {
+			lead = 0
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO signing_keys (id, algorithm, private_key, created_at, activates_at)
+			 VALUES ($1, $2, $3, NOW(), $4)`,
+			key.ID, key.Algorithm, encoded, time.Now().Add(lead),
+		)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("🔑 Published JWT signing key %s (%s), signing from %s", key.ID, key.Algorithm,
+			time.Now().Add(lead).Format(time.RFC3339))
+	}
+
+	// Retire the keys a newer active key has taken over from
+	_, err = tx.Exec(ctx,
+		`UPDATE signing_keys SET retired_at = NOW(), verify_until = $1
+		 WHERE retired_at IS NULL
+		   AND COALESCE(activates_at, created_at) < (
+			SELECT MAX(COALESCE(activates_at, created_at)) FROM signing_keys
+			WHERE retired_at IS NULL AND COALESCE(activates_at, created_at) <= NOW())`,
+		time.Now().Add(verifyWindow),
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return LoadSigningKeys()
+}
@@ -3,9 +3,10 @@ package models
 import (
 	"auth-api/internal/config"
 	"context"
-	"fmt"
-	"log"
+	"errors"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 type User struct {
@@ -15,175 +16,181 @@ type User struct {
 	IsVerified   bool      `json:"is_verified"`
 	Role         string    `json:"role"`
 	CreatedAt    time.Time `json:"created_at"`
+
+	// Profile
+	DisplayName string    `json:"display_name"`
+	GivenName   string    `json:"given_name"`
+	FamilyName  string    `json:"family_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	Locale      string    `json:"locale"`
+	Timezone    string    `json:"timezone"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Failed login tracking for backoff and lockout
+	FailedLoginAttempts int        `json:"-"`
+	LastFailedLoginAt   *time.Time `json:"-"`
+	LockedUntil         *time.Time `json:"locked_until,omitempty"`
+
+	// Set while the account waits for its deletion grace period to end
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	PurgeAfter *time.Time `json:"purge_after,omitempty"`
+
+	// Status is one of the Status* constants, see account_status.go. A
+	// suspension or lock past its expiry reads as active.
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"status_reason,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+
+	// Set by admins: the user cannot sign in until they reset their password
+	PasswordResetRequired bool `json:"password_reset_required"`
+}
+
+// userColumns is the column list scanned by scanUser
+const userColumns = `id, email, password_hash, is_verified, role, created_at,
+	display_name, given_name, family_name, avatar_url, locale, timezone, updated_at,
+	failed_login_attempts, last_failed_login_at, locked_until, deleted_at, purge_after,
+	status, status_reason, status_expires_at, status_changed_at, password_reset_required`
+
+func scanUser(row pgx.Row) (*User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.IsVerified, &user.Role, &user.CreatedAt,
+		&user.DisplayName, &user.GivenName, &user.FamilyName, &user.AvatarURL, &user.Locale, &user.Timezone, &user.UpdatedAt,
+		&user.FailedLoginAttempts, &user.LastFailedLoginAt, &user.LockedUntil, &user.DeletedAt, &user.PurgeAfter,
+		&user.Status, &user.StatusReason, &user.StatusExpiresAt, &user.StatusChangedAt, &user.PasswordResetRequired)
+	if err != nil {
+		return nil, err
+	}
+	user.expireStatus(time.Now())
+	return &user, nil
 }
 
 func CreateUser(email, passwordHash string) error {
 	query := `
-		INSERT INTO users (email, password_hash, is_verified, role, created_at)
-		VALUES ($1, $2, false, 'user', NOW())
+		WITH created AS (
+			INSERT INTO users (email, password_hash, is_verified, role, created_at)
+			VALUES ($1, $2, false, 'user', NOW())
+			RETURNING id
+		)
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT created.id, roles.id FROM created, roles WHERE roles.name = 'user'
 	`
 	_, err := config.DB.Exec(context.Background(), query, email, passwordHash)
 	return err
 }
 
-func StoreOTP(email, otp string) error {
-    expires := time.Now().Add(15 * time.Minute)
+func UpdateUserPassword(email, passwordHash string) error {
     _, err := config.DB.Exec(context.Background(),
-        `INSERT INTO otp_verifications (email, otp, expires_at) VALUES ($1, $2, $3)
-         ON CONFLICT (email) DO UPDATE SET otp = $2, expires_at = $3`,
-        email, otp, expires,
+        `UPDATE users SET password_hash = $1 WHERE email = $2`,
+        passwordHash, email,
     )
     return err
 }
 
-func StorePasswordResetOTP(email, otp string) error {
-    expires := time.Now().Add(15 * time.Minute)
-    _, err := config.DB.Exec(context.Background(),
-        `INSERT INTO password_reset_otps (email, otp, expires_at) VALUES ($1, $2, $3)
-         ON CONFLICT (email) DO UPDATE SET otp = $2, expires_at = $3`,
-        email, otp, expires,
-    )
-    return err
+// UpdatePasswordHashIfUnchanged replaces the user's password hash only if it
+// is still oldHash, so upgrading a hash after login cannot undo a password
+// change that committed in the meantime. It reports whether the hash was
+// replaced.
+func UpdatePasswordHashIfUnchanged(userID, oldHash, newHash string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET password_hash = $3 WHERE id = $1 AND password_hash = $2`,
+		userID, oldHash, newHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
 }
 
-func VerifyPasswordResetOTP(email, otp string) error {
-    // First, check if OTP exists and is not expired
-    var storedOTP string
-    var expiresAt time.Time
-    
-    err := config.DB.QueryRow(context.Background(),
-        `SELECT otp, expires_at FROM password_reset_otps WHERE email = $1`,
-        email,
-    ).Scan(&storedOTP, &expiresAt)
-    
-    if err != nil {
-        return fmt.Errorf("invalid email or OTP")
-    }
-    
-    // Check if OTP is expired
-    if time.Now().After(expiresAt) {
-        return fmt.Errorf("OTP has expired")
-    }
-    
-    // Check if OTP matches
-    if storedOTP != otp {
-        return fmt.Errorf("invalid OTP")
-    }
-    
-    // Delete the used OTP
-    _, err = config.DB.Exec(context.Background(),
-        `DELETE FROM password_reset_otps WHERE email = $1`,
-        email,
-    )
-    
-    return err
-}
+var ErrEmailTaken = errors.New("email is already in use")
 
-func UpdateUserPassword(email, passwordHash string) error {
-    _, err := config.DB.Exec(context.Background(),
-        `UPDATE users SET password_hash = $1 WHERE email = $2`,
-        passwordHash, email,
-    )
-    return err
+// UpdateUserEmail changes the user's email address, which counts as verified
+// since the new address had to confirm the change. It returns ErrEmailTaken
+// when another account uses the address.
+func UpdateUserEmail(userID, email string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET email = $2, is_verified = true, updated_at = NOW() WHERE id = $1`,
+		userID, email,
+	)
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	return err
 }
 
-func VerifyUserOTP(email, otp string) error {
-    // First, check if OTP exists and is not expired
-    var storedOTP string
-    var expiresAt time.Time
-    
-    err := config.DB.QueryRow(context.Background(),
-        `SELECT otp, expires_at FROM otp_verifications WHERE email = $1`,
-        email,
-    ).Scan(&storedOTP, &expiresAt)
-    
-    if err != nil {
-        return err
-    }
-    
-    // Check if OTP is expired
-    if time.Now().After(expiresAt) {
-        return fmt.Errorf("OTP has expired")
-    }
-    
-    // Check if OTP matches
-    if storedOTP != otp {
-        return fmt.Errorf("invalid OTP")
-    }
-    
-    // Update user verification status
-    _, err = config.DB.Exec(context.Background(),
-        `UPDATE users SET is_verified = true WHERE email = $1`,
-        email,
-    )
-    if err != nil {
-        return err
-    }
-    
-    // Delete the used OTP
-    _, err = config.DB.Exec(context.Background(),
-        `DELETE FROM otp_verifications WHERE email = $1`,
-        email,
-    )
-    
-    return err
+// MarkUserVerified marks the user's email as verified
+func MarkUserVerified(userID string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET is_verified = true WHERE id = $1`,
+		userID,
+	)
+	return err
 }
 
 func GetUserByEmail(email string) (*User, error) {
-	var user User
-	err := config.DB.QueryRow(context.Background(),
-		`SELECT id, email, password_hash, is_verified, role, created_at 
-		 FROM users WHERE email = $1`,
+	return scanUser(config.DB.QueryRow(context.Background(),
+		`SELECT `+userColumns+` FROM users WHERE email = $1`,
 		email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.IsVerified, &user.Role, &user.CreatedAt)
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	return &user, nil
+	))
 }
 
 func GetUserByID(userID string) (*User, error) {
-	var user User
-	err := config.DB.QueryRow(context.Background(),
-		`SELECT id, email, password_hash, is_verified, role, created_at 
-		 FROM users WHERE id = $1`,
+	return scanUser(config.DB.QueryRow(context.Background(),
+		`SELECT `+userColumns+` FROM users WHERE id = $1`,
 		userID,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.IsVerified, &user.Role, &user.CreatedAt)
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	return &user, nil
+	))
 }
 
 type RefreshToken struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	TokenHash string    `json:"-"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	FamilyID  string     `json:"family_id"`
+	ParentID  *string    `json:"parent_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+
+	// Device metadata of the session, copied to every token in the family
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	DeviceName string     `json:"device_name"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+
+	// OrganizationID is the organization the session is working in, also
+	// copied to every token in the family
+	OrganizationID *string `json:"organization_id"`
 }
 
-func StoreRefreshToken(userID, tokenHash string, expiresAt time.Time) error {
+// StoreRefreshToken inserts a refresh token. An empty FamilyID starts a new
+// family (a new login session); the generated ID and FamilyID are written
+// back to token.
+func StoreRefreshToken(token *RefreshToken) error {
 	query := `
-		INSERT INTO refresh_tokens (user_id, token_hash, expires_at, created_at)
-		VALUES ($1, $2, $3, NOW())
+		INSERT INTO refresh_tokens (user_id, family_id, parent_id, token_hash, expires_at,
+			user_agent, ip_address, device_name, organization_id, last_used_at, created_at)
+		VALUES ($1, COALESCE(NULLIF($2, '')::uuid, gen_random_uuid()), $3, $4, $5, $6, $7, $8, $9, NOW(), NOW())
+		RETURNING id, family_id, last_used_at, created_at
 	`
-    log.Println("📬 query : ", query)
-	_, err := config.DB.Exec(context.Background(), query, userID, tokenHash, expiresAt)
-	return err
+	return config.DB.QueryRow(context.Background(), query,
+		token.UserID, token.FamilyID, token.ParentID, token.TokenHash, token.ExpiresAt,
+		token.UserAgent, token.IPAddress, token.DeviceName, token.OrganizationID,
+	).Scan(&token.ID, &token.FamilyID, &token.LastUsedAt, &token.CreatedAt)
 }
 
 func GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
 	var token RefreshToken
 	err := config.DB.QueryRow(context.Background(),
-		`SELECT id, user_id, token_hash, expires_at, created_at 
+		`SELECT id, user_id, family_id, parent_id, token_hash, expires_at, created_at, rotated_at, revoked_at,
+		        COALESCE(user_agent, ''), COALESCE(ip_address, ''), COALESCE(device_name, ''), last_used_at,
+		        organization_id
 		 FROM refresh_tokens WHERE token_hash = $1`,
 		tokenHash,
-	).Scan(&token.ID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt)
+	).Scan(&token.ID, &token.UserID, &token.FamilyID, &token.ParentID, &token.TokenHash,
+		&token.ExpiresAt, &token.CreatedAt, &token.RotatedAt, &token.RevokedAt,
+		&token.UserAgent, &token.IPAddress, &token.DeviceName, &token.LastUsedAt,
+		&token.OrganizationID)
 	
 	if err != nil {
 		return nil, err
@@ -192,6 +199,61 @@ func GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
 	return &token, nil
 }
 
+// ListRefreshTokensForUser returns every stored refresh token of the user,
+// including rotated and revoked ones, newest first
+func ListRefreshTokensForUser(userID string) ([]RefreshToken, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT id, user_id, family_id, parent_id, token_hash, expires_at, created_at, rotated_at, revoked_at,
+		        COALESCE(user_agent, ''), COALESCE(ip_address, ''), COALESCE(device_name, ''), last_used_at,
+		        organization_id
+		 FROM refresh_tokens WHERE user_id = $1 ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []RefreshToken{}
+	for rows.Next() {
+		var token RefreshToken
+		if err := rows.Scan(&token.ID, &token.UserID, &token.FamilyID, &token.ParentID, &token.TokenHash,
+			&token.ExpiresAt, &token.CreatedAt, &token.RotatedAt, &token.RevokedAt,
+			&token.UserAgent, &token.IPAddress, &token.DeviceName, &token.LastUsedAt,
+			&token.OrganizationID); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// MarkRefreshTokenRotated flags a refresh token as used. It returns false
+// when the token had already been rotated by a concurrent request.
+func MarkRefreshTokenRotated(tokenID string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`UPDATE refresh_tokens SET rotated_at = NOW() WHERE id = $1 AND rotated_at IS NULL`,
+		tokenID,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token in a family together
+// with the access tokens issued for that session
+func RevokeRefreshTokenFamily(familyID string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`,
+		familyID,
+	)
+	if err != nil {
+		return err
+	}
+	return RevokeAccessTokensForSession(familyID)
+}
+
 func DeleteRefreshToken(tokenHash string) error {
 	query := `DELETE FROM refresh_tokens WHERE token_hash = $1`
 	_, err := config.DB.Exec(context.Background(), query, tokenHash)
@@ -213,27 +275,28 @@ func DeleteAllRefreshTokensForUser(userID string) error {
 type AccessToken struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
+	SessionID *string   `json:"session_id"`
 	TokenHash string    `json:"-"`
 	ExpiresAt time.Time `json:"expires_at"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
-func StoreAccessToken(userID, tokenHash string, expiresAt time.Time) error {
+func StoreAccessToken(userID, sessionID, tokenHash string, expiresAt time.Time) error {
 	query := `
-		INSERT INTO access_tokens (user_id, token_hash, expires_at, created_at)
-		VALUES ($1, $2, $3, NOW())
+		INSERT INTO access_tokens (user_id, session_id, token_hash, expires_at, created_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, NOW())
 	`
-	_, err := config.DB.Exec(context.Background(), query, userID, tokenHash, expiresAt)
+	_, err := config.DB.Exec(context.Background(), query, userID, sessionID, tokenHash, expiresAt)
 	return err
 }
 
 func GetAccessTokenByHash(tokenHash string) (*AccessToken, error) {
 	var token AccessToken
 	err := config.DB.QueryRow(context.Background(),
-		`SELECT id, user_id, token_hash, expires_at, created_at 
+		`SELECT id, user_id, session_id, token_hash, expires_at, created_at 
 		 FROM access_tokens WHERE token_hash = $1`,
 		tokenHash,
-	).Scan(&token.ID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt)
+	).Scan(&token.ID, &token.UserID, &token.SessionID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt)
 	
 	if err != nil {
 		return nil, err
@@ -260,3 +323,9 @@ func DeleteAllAccessTokensForUser(userID string) error {
 	return err
 }
 
+func DeleteAccessTokensForSession(sessionID string) error {
+	query := `DELETE FROM access_tokens WHERE session_id = $1`
+	_, err := config.DB.Exec(context.Background(), query, sessionID)
+	return err
+}
+
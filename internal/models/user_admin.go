@@ -0,0 +1,218 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"auth-api/internal/utils"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UserSearch filters, sorts and pages through users for admins. Zero values
+// leave a filter out.
+type UserSearch struct {
+	EmailPrefix string
+	Role        string
+	// Status matches the stored status, so suspensions and locks that have
+	// expired but not been cleared yet still match
+	Status        string
+	Verified      *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Sort is "created_at" (default) or "email"
+	Sort       string
+	Descending bool
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	Limit  int
+}
+
+// UserPage is one page of search results. NextCursor is empty on the last page.
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// userCursor is the position after the last user of a page: the sort value
+// and the ID, which breaks ties
+type userCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// SearchUsers returns a page of users matching the search. Pages are keyed on
+// the sort column and user ID, so they stay stable while users sign up.
+func SearchUsers(search UserSearch) (*UserPage, error) {
+	var conditions []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if search.EmailPrefix != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(search.EmailPrefix))
+		conditions = append(conditions, "LOWER(email) LIKE "+arg(escaped+"%"))
+	}
+	if search.Role != "" {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+			WHERE ur.user_id = users.id AND r.name = `+arg(search.Role)+`)`)
+	}
+	if search.Status != "" {
+		conditions = append(conditions, "status = "+arg(search.Status))
+	}
+	if search.Verified != nil {
+		conditions = append(conditions, "is_verified = "+arg(*search.Verified))
+	}
+	if search.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= "+arg(*search.CreatedAfter))
+	}
+	if search.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(*search.CreatedBefore))
+	}
+
+	column := "created_at"
+	if search.Sort == "email" {
+		column = "email"
+	}
+	direction, compare := "ASC", ">"
+	if search.Descending {
+		direction, compare = "DESC", "<"
+	}
+
+	if search.Cursor != "" {
+		cursor, err := decodeUserCursor(search.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		var value any = cursor.Value
+		if column == "created_at" {
+			if value, err = time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+				return nil, ErrInvalidCursor
+			}
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", column, compare, arg(value), arg(cursor.ID)))
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// One extra row tells whether there is a next page
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", column, direction, direction, arg(search.Limit+1))
+
+	rows, err := config.DB.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &UserPage{Users: []User{}}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Users = append(page.Users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Users) > search.Limit {
+		page.Users = page.Users[:search.Limit]
+		last := page.Users[len(page.Users)-1]
+		cursor := userCursor{Value: last.CreatedAt.Format(time.RFC3339Nano), ID: last.ID}
+		if column == "email" {
+			cursor.Value = last.Email
+		}
+		page.NextCursor = encodeUserCursor(cursor)
+	}
+	return page, nil
+}
+
+func encodeUserCursor(cursor userCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserCursor(s string) (*userCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || !utils.IsUUID(cursor.ID) {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// SetUserRole makes a role the user's primary role (users.role) in place of
+// the current one, which is taken away from them. Other assigned roles are
+// kept. It returns false when the role does not exist.
+func SetUserRole(userID, roleName string) (bool, error) {
+	ctx := context.Background()
+	tx, err := config.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO user_roles (user_id, role_id, created_at)
+		 SELECT $1, id, NOW() FROM roles WHERE name = $2
+		 ON CONFLICT DO NOTHING`,
+		userID, roleName,
+	)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		if err := tx.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, roleName,
+		).Scan(&exists); err != nil || !exists {
+			return false, err
+		}
+	}
+
+	// The old primary role is replaced, not kept alongside the new one
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM user_roles ur USING users u, roles r
+		 WHERE ur.user_id = u.id AND ur.role_id = r.id AND u.id = $1
+		   AND r.name = u.role AND u.role <> $2`,
+		userID, roleName,
+	); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET role = $2, updated_at = NOW() WHERE id = $1`,
+		userID, roleName,
+	); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+
+	evictPermissions(&userID)
+	return true, nil
+}
+
+// RequirePasswordReset stops the user from signing in until they have reset
+// their password
+func RequirePasswordReset(userID string) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE users SET password_reset_required = true, updated_at = NOW() WHERE id = $1`,
+		userID,
+	)
+	return err
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"auth-api/internal/config"
+	"context"
+	"time"
+)
+
+// WebAuthnCredential is a registered passkey or security key. Data holds the
+// JSON-encoded credential record (public key, sign count, flags, ...).
+type WebAuthnCredential struct {
+	ID           string     `json:"id"`
+	UserID       string     `json:"user_id"`
+	CredentialID []byte     `json:"-"`
+	Name         string     `json:"name"`
+	Data         []byte     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+// WebAuthnSession holds the server side state of a registration or login ceremony
+type WebAuthnSession struct {
+	ID        string
+	UserID    *string
+	Ceremony  string
+	Data      []byte
+	ExpiresAt time.Time
+}
+
+func CreateWebAuthnCredential(credential *WebAuthnCredential) error {
+	return config.DB.QueryRow(context.Background(),
+		`INSERT INTO webauthn_credentials (user_id, credential_id, name, data, created_at)
+		 VALUES ($1, $2, $3, $4, NOW())
+		 RETURNING id, created_at`,
+		credential.UserID, credential.CredentialID, credential.Name, credential.Data,
+	).Scan(&credential.ID, &credential.CreatedAt)
+}
+
+func ListWebAuthnCredentials(userID string) ([]WebAuthnCredential, error) {
+	rows, err := config.DB.Query(context.Background(),
+		`SELECT id, user_id, credential_id, name, data, created_at, last_used_at
+		 FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []WebAuthnCredential{}
+	for rows.Next() {
+		var credential WebAuthnCredential
+		if err := rows.Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.Name,
+			&credential.Data, &credential.CreatedAt, &credential.LastUsedAt); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+func CountWebAuthnCredentials(userID string) (int, error) {
+	var count int
+	err := config.DB.QueryRow(context.Background(),
+		`SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = $1`,
+		userID,
+	).Scan(&count)
+	return count, err
+}
+
+func GetWebAuthnCredentialByCredentialID(credentialID []byte) (*WebAuthnCredential, error) {
+	var credential WebAuthnCredential
+	err := config.DB.QueryRow(context.Background(),
+		`SELECT id, user_id, credential_id, name, data, created_at, last_used_at
+		 FROM webauthn_credentials WHERE credential_id = $1`,
+		credentialID,
+	).Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.Name,
+		&credential.Data, &credential.CreatedAt, &credential.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// UpdateWebAuthnCredentialUsage stores the credential record after a login
+// (the sign count changes) and records when it was used
+func UpdateWebAuthnCredentialUsage(credentialID, data []byte) error {
+	_, err := config.DB.Exec(context.Background(),
+		`UPDATE webauthn_credentials SET data = $2, last_used_at = NOW() WHERE credential_id = $1`,
+		credentialID, data,
+	)
+	return err
+}
+
+func RenameWebAuthnCredential(userID, id, name string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`UPDATE webauthn_credentials SET name = $3 WHERE id = $2 AND user_id = $1`,
+		userID, id, name,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func DeleteWebAuthnCredential(userID, id string) (bool, error) {
+	tag, err := config.DB.Exec(context.Background(),
+		`DELETE FROM webauthn_credentials WHERE id = $2 AND user_id = $1`,
+		userID, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func CreateWebAuthnSession(session *WebAuthnSession) error {
+	return config.DB.QueryRow(context.Background(),
+		`INSERT INTO webauthn_sessions (user_id, ceremony, data, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, NOW())
+		 RETURNING id`,
+		session.UserID, session.Ceremony, session.Data, session.ExpiresAt,
+	).Scan(&session.ID)
+}
+
+// ConsumeWebAuthnSession deletes and returns a ceremony session so it can
+// only be finished once
+func ConsumeWebAuthnSession(id, ceremony string) (*WebAuthnSession, error) {
+	var session WebAuthnSession
+	err := config.DB.QueryRow(context.Background(),
+		`DELETE FROM webauthn_sessions WHERE id = $1 AND ceremony = $2
+		 RETURNING id, user_id, ceremony, data, expires_at`,
+		id, ceremony,
+	).Scan(&session.ID, &session.UserID, &session.Ceremony, &session.Data, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func DeleteExpiredWebAuthnSessions() error {
+	_, err := config.DB.Exec(context.Background(), `DELETE FROM webauthn_sessions WHERE expires_at < NOW()`)
+	return err
+}
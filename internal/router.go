@@ -3,6 +3,7 @@ package internal
 import (
 	"auth-api/internal/handlers"
 	"auth-api/internal/middleware"
+	"auth-api/internal/models"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -12,21 +13,111 @@ func SetupRoutes(app *fiber.App) {
 		return c.SendString("OK")
 	})
 
+	// Public keys for verifying access tokens
+	app.Get("/.well-known/jwks.json", handlers.JWKS)
+
 	auth := app.Group("/auth")
 	auth.Post("/signup", handlers.SignUp)
 	auth.Post("/verify-user", handlers.VerifyUser)
+	auth.Post("/resend-verification", handlers.ResendVerification)
 	auth.Post("/login", handlers.Login)
 	auth.Post("/forgot-password", handlers.ForgotPassword)
 	auth.Post("/reset-password", handlers.ResetPassword)
+	auth.Post("/password-strength", handlers.PasswordStrength)
 	auth.Post("/refresh", handlers.RefreshToken)
 	auth.Post("/logout", handlers.Logout)
+	auth.Post("/mfa/verify", handlers.VerifyMFA)
+	auth.Post("/passwordless/start", handlers.PasswordlessStart)
+	auth.Post("/passwordless/complete", handlers.PasswordlessComplete)
+	auth.Post("/email-change/cancel", handlers.CancelEmailChange)
+	auth.Post("/account/restore", handlers.RestoreAccount)
+
+	// Data export downloads are authenticated by the token in the emailed link
+	app.Get("/exports/:id/download", handlers.DownloadDataExport)
+
+	// WebAuthn ceremonies: passkey registration (signed in, and begun with the
+	// password), passwordless passkey login, and security keys as the second
+	// factor of a password login
+	webauthn := auth.Group("/webauthn")
+	webauthn.Post("/register/begin", middleware.AuthMiddleware(), handlers.BeginWebAuthnRegistration)
+	webauthn.Post("/register/finish", middleware.AuthMiddleware(), handlers.FinishWebAuthnRegistration)
+	webauthn.Post("/login/begin", handlers.BeginWebAuthnLogin)
+	webauthn.Post("/login/finish", handlers.FinishWebAuthnLogin)
+	webauthn.Post("/mfa/begin", handlers.BeginWebAuthnMFA)
+	webauthn.Post("/mfa/finish", handlers.FinishWebAuthnMFA)
+
+	// Token introspection (RFC 7662) and revocation (RFC 7009) for other
+	// services, authenticated with client credentials
+	oauth := app.Group("/oauth", middleware.ClientAuthMiddleware())
+	oauth.Post("/introspect", handlers.Introspect)
+	oauth.Post("/revoke", handlers.Revoke)
 
 	// Protected routes - require authentication
 	protected := app.Group("/api", middleware.AuthMiddleware())
 	protected.Get("/profile", handlers.GetProfile)
 	protected.Put("/profile", handlers.UpdateProfile)
+	protected.Patch("/profile", handlers.UpdateProfile)
+	protected.Put("/password", handlers.ChangePassword)
+	protected.Post("/email", handlers.ChangeEmail)
+	protected.Post("/email/confirm", handlers.ConfirmEmailChange)
+	protected.Delete("/account", handlers.DeleteAccount)
+	protected.Get("/account/export", handlers.ExportAccountData)
+	protected.Get("/account/exports/:id", handlers.GetDataExport)
+	protected.Get("/sessions", handlers.ListSessions)
+	protected.Delete("/sessions", handlers.RevokeOtherSessions)
+	protected.Delete("/sessions/:session_id", handlers.RevokeSession)
+	protected.Get("/mfa", handlers.GetMFAStatus)
+	protected.Post("/mfa/totp/setup", handlers.SetupTOTP)
+	protected.Post("/mfa/totp/confirm", handlers.ConfirmTOTP)
+	protected.Delete("/mfa/totp", handlers.DisableTOTP)
+	protected.Post("/mfa/recovery-codes", handlers.RegenerateRecoveryCodes)
+	protected.Get("/webauthn/credentials", handlers.ListWebAuthnCredentials)
+	protected.Patch("/webauthn/credentials/:id", handlers.RenameWebAuthnCredential)
+	protected.Delete("/webauthn/credentials/:id", handlers.DeleteWebAuthnCredential)
+	protected.Get("/orgs", handlers.ListOrganizations)
+	protected.Post("/orgs", handlers.CreateOrganization)
+	protected.Post("/orgs/switch", handlers.SwitchOrganization)
+	protected.Post("/orgs/invitations/accept", handlers.AcceptOrganizationInvitation)
+
+	// Organization routes - scoped to the organization of the caller's session
+	org := protected.Group("/org", middleware.RequireOrganization())
+	org.Get("/", handlers.GetOrganization)
+	org.Get("/members", handlers.ListOrganizationMembers)
+	org.Patch("/members/:user_id", middleware.RequireOrgRole(models.OrgRoleAdmin), handlers.UpdateOrganizationMember)
+	org.Delete("/members/:user_id", handlers.RemoveOrganizationMember)
+	org.Get("/invitations", middleware.RequireOrgRole(models.OrgRoleAdmin), handlers.ListOrganizationInvitations)
+	org.Post("/invitations", middleware.RequireOrgRole(models.OrgRoleAdmin), handlers.InviteOrganizationMember)
+	org.Delete("/invitations/:id", middleware.RequireOrgRole(models.OrgRoleAdmin), handlers.DeleteOrganizationInvitation)
+
+	// Admin routes - open to support staff and every role above them; each
+	// route requires its own permission or role on top
+	admin := app.Group("/admin", middleware.AuthMiddleware(), middleware.RoleMiddleware("support"))
+	admin.Get("/data", middleware.AdminMiddleware(), handlers.AdminOnly)
+	admin.Get("/users", middleware.RequirePermission("users:read"), handlers.ListUsers)
+	admin.Get("/users/:id", middleware.RequirePermission("users:read"), handlers.AdminGetUser)
+	admin.Put("/users/:id/role", middleware.RequirePermission("users:write"), handlers.SetUserRole)
+	admin.Post("/users/:id/verify", middleware.RequirePermission("users:write"), handlers.AdminVerifyUser)
+	admin.Post("/users/:id/password-reset", middleware.RequirePermission("users:write"), handlers.ForcePasswordReset)
+	admin.Post("/users/:id/suspend", middleware.RequirePermission("users:write"), handlers.SuspendUser)
+	admin.Post("/users/:id/reactivate", middleware.RequirePermission("users:write"), handlers.ReactivateUser)
+	admin.Get("/users/:id/sessions", middleware.RequirePermission("sessions:read"), handlers.AdminListUserSessions)
+	admin.Delete("/users/:id/sessions", middleware.RequirePermission("sessions:write"), handlers.RevokeUserSessions)
+	admin.Delete("/users/:id/sessions/:session_id", middleware.RequirePermission("sessions:write"), handlers.AdminRevokeUserSession)
+	admin.Post("/users/:id/unlock", middleware.RequirePermission("users:write"), handlers.UnlockUser)
+	admin.Get("/oauth/clients", middleware.RequirePermission("oauth_clients:read"), handlers.ListOAuthClients)
+	admin.Post("/oauth/clients", middleware.RequirePermission("oauth_clients:write"), handlers.CreateOAuthClient)
+	admin.Delete("/oauth/clients/:client_id", middleware.RequirePermission("oauth_clients:write"), handlers.DeleteOAuthClient)
 
-	// Admin routes - require admin role
-	admin := app.Group("/admin", middleware.AuthMiddleware(), middleware.AdminMiddleware())
-	admin.Get("/data", handlers.AdminOnly)
+	// Roles, permissions and role assignments
+	admin.Get("/roles", middleware.RequirePermission("roles:read"), handlers.ListRoles)
+	admin.Post("/roles", middleware.RequirePermission("roles:write"), handlers.CreateRole)
+	admin.Delete("/roles/:name", middleware.RequirePermission("roles:write"), handlers.DeleteRole)
+	admin.Put("/roles/:name/permissions/:permission", middleware.RequirePermission("roles:write"), handlers.GrantPermission)
+	admin.Delete("/roles/:name/permissions/:permission", middleware.RequirePermission("roles:write"), handlers.RevokePermission)
+	admin.Get("/permissions", middleware.RequirePermission("roles:read"), handlers.ListPermissions)
+	admin.Post("/permissions", middleware.RequirePermission("roles:write"), handlers.CreatePermission)
+	admin.Delete("/permissions/:name", middleware.RequirePermission("roles:write"), handlers.DeletePermission)
+	admin.Get("/users/:id/roles", middleware.RequirePermission("roles:read"), handlers.ListUserRoles)
+	admin.Put("/users/:id/roles/:role", middleware.RequirePermission("roles:write"), handlers.AssignUserRole)
+	admin.Delete("/users/:id/roles/:role", middleware.RequirePermission("roles:write"), handlers.RemoveUserRole)
 }
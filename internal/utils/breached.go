@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, password := range strings.Fields(commonPasswordList) {
+		set[password] = struct{}{}
+	}
+	return set
+}()
+
+// IsBreachedPassword reports whether the password is one of the bundled
+// common passwords or appears in the file at PASSWORD_BREACHED_LIST_FILE.
+// That file lists uppercase SHA-1 hashes one per line in sorted order,
+// optionally followed by ":count" as in the Have I Been Pwned downloads; it
+// is binary searched on disk, so it can be far larger than memory.
+func IsBreachedPassword(password string) (bool, error) {
+	if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+		return true, nil
+	}
+
+	path := os.Getenv("PASSWORD_BREACHED_LIST_FILE")
+	if path == "" {
+		return false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	sum := sha1.Sum([]byte(password))
+	return searchSortedHashFile(f, info.Size(), strings.ToUpper(hex.EncodeToString(sum[:])))
+}
+
+// searchSortedHashFile binary searches the byte range of a sorted file of
+// lines for one whose hash (the part before any ':') equals target
+func searchSortedHashFile(f io.ReaderAt, size int64, target string) (bool, error) {
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := lineAtOrAfter(f, size, mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= size {
+			hi = mid
+			continue
+		}
+
+		hash, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ":")
+		switch strings.Compare(strings.ToUpper(hash), target) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = start + int64(len(line))
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineAtOrAfter returns the first line that starts at or after offset,
+// including its newline, and where it starts
+func lineAtOrAfter(f io.ReaderAt, size, offset int64) (int64, string, error) {
+	start := offset
+	if offset > 0 {
+		start = offset - 1
+	}
+	reader := bufio.NewReader(io.NewSectionReader(f, start, size-start))
+
+	if offset > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return size, "", nil
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	if line == "" {
+		return size, "", nil
+	}
+	return start, line, nil
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envInt reads a positive integer from the environment
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// envIntRange reads an integer between min and max from the environment.
+// Values outside the range are logged and replaced with fallback.
+func envIntRange(key string, fallback, min, max int) int {
+	n := envInt(key, fallback)
+	if n < min || n > max {
+		log.Printf("Ignoring %s=%d, it must be between %d and %d", key, n, min, max)
+		return fallback
+	}
+	return n
+}
+
+// envBool reads a boolean from the environment
+func envBool(key string, fallback bool) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "true", "1", "yes":
+		return true
+	case "false", "0", "no":
+		return false
+	}
+	return fallback
+}
@@ -8,56 +8,79 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// AccessTokenTTL is the lifetime of an access token
+const AccessTokenTTL = 15 * time.Minute
+
+// RefreshTokenTTL is the lifetime of a refresh token
+const RefreshTokenTTL = 7 * 24 * time.Hour
+
 type Claims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
 	Role   string `json:"role"`
+	// Roles are all of the user's roles; Role is the primary one
+	Roles []string `json:"roles,omitempty"`
+	// Permissions are embedded unless RBAC_EMBED_PERMISSIONS is false, in
+	// which case they are resolved per request
+	Permissions []string `json:"permissions,omitempty"`
+	// SessionID is the refresh token family the access token belongs to
+	SessionID string `json:"sid,omitempty"`
+	// OrganizationID is the organization the session works in, and OrgRole
+	// the user's role there; both are empty for users without one
+	OrganizationID string `json:"org_id,omitempty"`
+	OrgRole        string `json:"org_role,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateAccessToken(userID, email, role string) (string, error) {
+// GenerateAccessToken signs an access token carrying claims. Its ID, expiry,
+// issue time and issuer are filled in.
+func GenerateAccessToken(claims Claims) (string, error) {
 	// Access token expires in 15 minutes
-	expirationTime := time.Now().Add(15 * time.Minute)
-	
-	claims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "auth-api",
-		},
+	expirationTime := time.Now().Add(AccessTokenTTL)
+
+	// The jti makes every access token unique so it can be revoked on its own
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(getJWTSecret()))
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		ExpiresAt: jwt.NewNumericDate(expirationTime),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Issuer:    "auth-api",
+	}
+
+	return signToken(&claims)
 }
 
 func GenerateRefreshToken(userID, email string) (string, error) {
 	// Refresh token expires in 7 days
-	expirationTime := time.Now().Add(7 * 24 * time.Hour)
+	expirationTime := time.Now().Add(RefreshTokenTTL)
+
+	jti, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
 	
 	claims := &Claims{
 		UserID: userID,
 		Email:  email,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "auth-api",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(getJWTSecret()))
+	return signToken(claims)
 }
 
 func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(getJWTSecret()), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 
 	if err != nil {
 		return nil, err
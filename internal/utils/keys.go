@@ -0,0 +1,274 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is an asymmetric key used to sign and verify JWTs.
+// Only the newest active key signs. A new key is published ahead of
+// ActivatesAt so it is known everywhere before it signs anything; retired
+// keys stay in the key ring until VerifyUntil so tokens they signed can
+// still be validated.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	CreatedAt   time.Time
+	ActivatesAt time.Time
+	RetiredAt   *time.Time
+	VerifyUntil *time.Time
+}
+
+// JWK is a public key in RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is the document served from /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	keyRingMu sync.RWMutex
+	keyRing   = map[string]*SigningKey{}
+)
+
+// SigningAlgorithm returns the configured JWT signing algorithm.
+// HS256 keeps the legacy shared-secret mode and disables the key ring.
+func SigningAlgorithm() string {
+	switch alg := os.Getenv("JWT_SIGNING_ALG"); alg {
+	case "HS256", "RS256", "ES256", "EdDSA":
+		return alg
+	default:
+		return "RS256"
+	}
+}
+
+// UsesSharedSecret reports whether tokens are signed with JWT_SECRET
+func UsesSharedSecret() bool {
+	return SigningAlgorithm() == "HS256"
+}
+
+// GenerateSigningKey creates a new key for the given algorithm
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var priv crypto.Signer
+	var err error
+
+	switch alg {
+	case "RS256":
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:         hex.EncodeToString(id),
+		Algorithm:  alg,
+		PrivateKey: priv,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// EncodePrivateKey serializes a private key as a PKCS#8 PEM block
+func EncodePrivateKey(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// DecodePrivateKey parses a PKCS#8 PEM block produced by EncodePrivateKey
+func DecodePrivateKey(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid private key PEM")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+	return signer, nil
+}
+
+// SetSigningKeys replaces the key ring
+func SetSigningKeys(keys []*SigningKey) {
+	ring := make(map[string]*SigningKey, len(keys))
+	for _, key := range keys {
+		ring[key.ID] = key
+	}
+
+	keyRingMu.Lock()
+	keyRing = ring
+	keyRingMu.Unlock()
+}
+
+// CurrentSigningKey returns the key used to sign new tokens: the newest key
+// that is active and not retired. It is worked out on every call, so all
+// instances switch to a published key at its activation time without
+// waiting for a reload.
+func CurrentSigningKey() *SigningKey {
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+
+	now := time.Now()
+	var current *SigningKey
+	for _, key := range keyRing {
+		if key.RetiredAt != nil || key.ActivatesAt.After(now) {
+			continue
+		}
+		if current == nil || key.ActivatesAt.After(current.ActivatesAt) {
+			current = key
+		}
+	}
+	return current
+}
+
+// PublicJWKS returns the public half of every key that can still verify tokens
+func PublicJWKS() JWKS {
+	keyRingMu.RLock()
+	defer keyRingMu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range keyRing {
+		if key.VerifyUntil != nil && now.After(*key.VerifyUntil) {
+			continue
+		}
+		if jwk, err := publicJWK(key); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	switch alg {
+	case "RS256":
+		return jwt.SigningMethodRS256
+	case "ES256":
+		return jwt.SigningMethodES256
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// signToken signs claims with the current key, or JWT_SECRET in HS256 mode
+func signToken(claims jwt.Claims) (string, error) {
+	if UsesSharedSecret() {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(getJWTSecret()))
+	}
+
+	key := CurrentSigningKey()
+	if key == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(signingMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// verificationKey resolves the key for a token being parsed
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if UsesSharedSecret() {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(getJWTSecret()), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no key id")
+	}
+
+	keyRingMu.RLock()
+	key, ok := keyRing[kid]
+	keyRingMu.RUnlock()
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+
+	if key.VerifyUntil != nil && time.Now().After(*key.VerifyUntil) {
+		return nil, errors.New("signing key expired")
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.PrivateKey.Public(), nil
+}
+
+func publicJWK(key *SigningKey) (JWK, error) {
+	jwk := JWK{Kid: key.ID, Alg: key.Algorithm, Use: "sig"}
+
+	switch pub := key.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return JWK{}, errors.New("unsupported public key type")
+	}
+
+	return jwk, nil
+}
@@ -2,15 +2,33 @@ package utils
 
 import (
 	"crypto/rand"
+	"math/big"
+	"strings"
 )
 
-func GenerateOTP() string {
-    const charset = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-    bytes := make([]byte, 6)
-    rand.Read(bytes)
-    result := make([]byte, 6)
+const (
+    // AlphanumericCodeAlphabet avoids lowercase so codes survive being read out
+    AlphanumericCodeAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+    NumericCodeAlphabet      = "0123456789"
+)
+
+// GenerateCode returns a random one-time code of the given length drawn
+// uniformly from alphabet
+func GenerateCode(length int, alphabet string) (string, error) {
+    max := big.NewInt(int64(len(alphabet)))
+    result := make([]byte, length)
     for i := range result {
-        result[i] = charset[bytes[i]%byte(len(charset))]
+        n, err := rand.Int(rand.Reader, max)
+        if err != nil {
+            return "", err
+        }
+        result[i] = alphabet[n.Int64()]
     }
-    return string(result)
+    return string(result), nil
+}
+
+// HashOTP hashes a one-time code for storage. The subject it was sent to
+// (usually an email) is mixed in so equal codes do not share a hash.
+func HashOTP(subject, otp string) string {
+    return HashToken(subject + ":" + strings.ToUpper(strings.TrimSpace(otp)))
 }
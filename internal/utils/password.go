@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy is the set of rules new passwords have to follow. Reuse of
+// previous passwords is checked by the handlers, which have the history.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// RejectBreached rejects common passwords and those in the breached
+	// password list, see IsBreachedPassword
+	RejectBreached bool
+	// RejectEmail rejects passwords containing the email address or its
+	// local part
+	RejectEmail bool
+	// MinScore is the lowest EstimatePasswordStrength score accepted
+	MinScore int
+}
+
+// PasswordViolation is one rule a password breaks
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password breaks, along with the
+// password's strength estimate for feedback
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+	Strength   PasswordStrength
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// CurrentPasswordPolicy returns the policy configured with PASSWORD_MIN_LENGTH
+// (default 8), PASSWORD_MAX_LENGTH (default 128), PASSWORD_REQUIRE_UPPER,
+// PASSWORD_REQUIRE_LOWER, PASSWORD_REQUIRE_DIGIT, PASSWORD_REQUIRE_SYMBOL
+// (default false), PASSWORD_REJECT_BREACHED and PASSWORD_REJECT_EMAIL
+// (default true) and PASSWORD_MIN_SCORE (default 0, any strength)
+func CurrentPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:      envInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:      envInt("PASSWORD_MAX_LENGTH", 128),
+		RequireUpper:   envBool("PASSWORD_REQUIRE_UPPER", false),
+		RequireLower:   envBool("PASSWORD_REQUIRE_LOWER", false),
+		RequireDigit:   envBool("PASSWORD_REQUIRE_DIGIT", false),
+		RequireSymbol:  envBool("PASSWORD_REQUIRE_SYMBOL", false),
+		RejectBreached: envBool("PASSWORD_REJECT_BREACHED", true),
+		RejectEmail:    envBool("PASSWORD_REJECT_EMAIL", true),
+		MinScore:       envInt("PASSWORD_MIN_SCORE", 0),
+	}
+}
+
+// ValidatePassword checks a new password for the account with the given email
+// against the current password policy. userInputs such as the user's names
+// are taken into account by the strength estimate. It returns a
+// *PasswordPolicyError listing every broken rule.
+func ValidatePassword(password, email string, userInputs ...string) error {
+	return CurrentPasswordPolicy().Validate(password, email, userInputs...)
+}
+
+// Validate checks a password against the policy, see ValidatePassword
+func (p PasswordPolicy) Validate(password, email string, userInputs ...string) error {
+	var violations []PasswordViolation
+	add := func(rule, message string) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: message})
+	}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		add("min_length", fmt.Sprintf("Password must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add("max_length", fmt.Sprintf("Password must be at most %d characters long", p.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		add("uppercase", "Password must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		add("lowercase", "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add("digit", "Password must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add("symbol", "Password must contain a symbol")
+	}
+
+	if p.RejectEmail && containsEmail(password, email) {
+		add("contains_email", "Password must not contain your email address")
+	}
+
+	if p.RejectBreached && password != "" {
+		breached, err := IsBreachedPassword(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			add("breached", "Password is too common or has appeared in a data breach")
+		}
+	}
+
+	strength := EstimatePasswordStrength(password, append([]string{email}, userInputs...)...)
+	if strength.Score < p.MinScore {
+		add("strength", "Password is too easy to guess")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations, Strength: strength}
+	}
+	return nil
+}
+
+// containsEmail reports whether the password contains the email address or
+// its local part. Local parts shorter than three characters are ignored.
+func containsEmail(password, email string) bool {
+	password, email = strings.ToLower(password), strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return len(local) >= 3 && strings.Contains(password, local)
+}
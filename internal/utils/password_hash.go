@@ -0,0 +1,326 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms, selected with PASSWORD_HASH_ALGORITHM
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+var (
+	ErrUnknownPasswordHash = errors.New("unknown password hash format")
+	ErrUnknownPepper       = errors.New("password hash uses an unknown pepper")
+)
+
+// PasswordHasher creates and checks the hashes of one algorithm. Stored
+// hashes are self-describing, so the hasher for a hash is picked by Handles.
+type PasswordHasher interface {
+	Name() string
+	Handles(hash string) bool
+	Hash(password string) (string, error)
+	Verify(password, hash string) (bool, error)
+	// NeedsRehash reports whether the hash was made with weaker parameters
+	// (or another pepper) than the hasher is configured with
+	NeedsRehash(hash string) bool
+}
+
+// CurrentPasswordHasher returns the hasher new passwords are hashed with.
+// Parameters come from the environment:
+//
+//	PASSWORD_HASH_ALGORITHM  argon2id (default) or bcrypt
+//	ARGON2_MEMORY            memory in KiB, 8 to 4194304 (default 65536)
+//	ARGON2_ITERATIONS        passes over the memory, 1 to 100 (default 3)
+//	ARGON2_PARALLELISM       lanes, 1 to 255 (default 2)
+//	BCRYPT_COST              bcrypt cost, 4 to 31 (default bcrypt.DefaultCost)
+//	PASSWORD_PEPPER          optional server-side secret mixed into argon2id hashes
+//	PASSWORD_PEPPER_ID       id of PASSWORD_PEPPER recorded in each hash (default "1");
+//	                         retired peppers stay usable as PASSWORD_PEPPER_<ID>
+func CurrentPasswordHasher() PasswordHasher {
+	if os.Getenv("PASSWORD_HASH_ALGORITHM") == PasswordHashBcrypt {
+		return bcryptHasher()
+	}
+	return argon2idHasher()
+}
+
+func passwordHashers() []PasswordHasher {
+	return []PasswordHasher{argon2idHasher(), bcryptHasher()}
+}
+
+// HashPassword hashes a password with the current hasher
+func HashPassword(password string) (string, error) {
+	return CurrentPasswordHasher().Hash(password)
+}
+
+// CheckPasswordHash reports whether password matches a hash made by any of
+// the supported hashers. A hash that cannot be checked, e.g. because its
+// pepper is no longer configured, does not match and is logged, as the
+// account cannot log in until that is fixed.
+func CheckPasswordHash(password, hash string) bool {
+	for _, hasher := range passwordHashers() {
+		if hasher.Handles(hash) {
+			ok, err := hasher.Verify(password, hash)
+			if err != nil {
+				log.Printf("Failed to verify %s password hash: %v", hasher.Name(), err)
+				return false
+			}
+			return ok
+		}
+	}
+	return false
+}
+
+// dummyHashes holds a throwaway hash per hasher configuration
+var dummyHashes sync.Map
+
+// CheckDummyPasswordHash checks password against a hash made with the current
+// hasher's parameters and throws the result away. Calling it when there is
+// no account to check against makes a failed login take as long as a wrong
+// password, so response times do not tell which emails are registered.
+func CheckDummyPasswordHash(password string) {
+	hasher := CurrentPasswordHasher()
+	key := fmt.Sprintf("%s %+v", hasher.Name(), hasher)
+
+	hash, ok := dummyHashes.Load(key)
+	if !ok {
+		secret, err := GenerateRandomToken(16)
+		if err != nil {
+			return
+		}
+		created, err := hasher.Hash(secret)
+		if err != nil {
+			return
+		}
+		hash, _ = dummyHashes.LoadOrStore(key, created)
+	}
+	CheckPasswordHash(password, hash.(string))
+}
+
+// PasswordNeedsRehash reports whether a stored hash should be replaced with
+// one from the current hasher, either because it uses another algorithm or
+// weaker parameters
+func PasswordNeedsRehash(hash string) bool {
+	current := CurrentPasswordHasher()
+	if !current.Handles(hash) {
+		return true
+	}
+	return current.NeedsRehash(hash)
+}
+
+// Upper bounds for the argon2id parameters read from the environment. They
+// keep the values inside the types argon2 takes and a hash within seconds.
+const (
+	argon2MaxMemory     = 4 * 1024 * 1024
+	argon2MaxIterations = 100
+)
+
+type argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+	PepperID    string
+}
+
+func argon2idHasher() *argon2idParams {
+	params := &argon2idParams{
+		Memory:      uint32(envIntRange("ARGON2_MEMORY", 64*1024, 8, argon2MaxMemory)),
+		Iterations:  uint32(envIntRange("ARGON2_ITERATIONS", 3, 1, argon2MaxIterations)),
+		Parallelism: uint8(envIntRange("ARGON2_PARALLELISM", 2, 1, math.MaxUint8)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	if os.Getenv("PASSWORD_PEPPER") != "" {
+		params.PepperID = os.Getenv("PASSWORD_PEPPER_ID")
+		if params.PepperID == "" {
+			params.PepperID = "1"
+		}
+	}
+	return params
+}
+
+func (p *argon2idParams) Name() string { return PasswordHashArgon2id }
+
+func (p *argon2idParams) Handles(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+// Hash returns the hash in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>. A peppered hash carries the
+// pepper's id as the keyid parameter.
+func (p *argon2idParams) Hash(password string) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	peppered, err := pepperPassword(password, p.PepperID)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(peppered), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Iterations, p.Parallelism)
+	if p.PepperID != "" {
+		params += ",keyid=" + base64.RawStdEncoding.EncodeToString([]byte(p.PepperID))
+	}
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (p *argon2idParams) Verify(password, hash string) (bool, error) {
+	stored, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	peppered, err := pepperPassword(password, stored.PepperID)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(peppered), salt, stored.Iterations, stored.Memory, stored.Parallelism, stored.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (p *argon2idParams) NeedsRehash(hash string) bool {
+	stored, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return stored.Memory < p.Memory ||
+		stored.Iterations < p.Iterations ||
+		stored.Parallelism < p.Parallelism ||
+		stored.KeyLength < p.KeyLength ||
+		stored.PepperID != p.PepperID
+}
+
+// decodeArgon2id parses a hash made by argon2idParams.Hash
+func decodeArgon2id(hash string) (*argon2idParams, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	params := &argon2idParams{}
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		switch name {
+		case "m", "t", "p":
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, nil, nil, ErrUnknownPasswordHash
+			}
+			switch name {
+			case "m":
+				params.Memory = uint32(n)
+			case "t":
+				params.Iterations = uint32(n)
+			case "p":
+				params.Parallelism = uint8(n)
+			}
+		case "keyid":
+			id, err := base64.RawStdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, nil, nil, ErrUnknownPasswordHash
+			}
+			params.PepperID = string(id)
+		}
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+	params.SaltLength = len(salt)
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// pepperPassword mixes the pepper with the given id into the password. An
+// empty id means the hash is not peppered.
+func pepperPassword(password, pepperID string) (string, error) {
+	if pepperID == "" {
+		return password, nil
+	}
+
+	pepper := os.Getenv("PASSWORD_PEPPER_" + strings.ToUpper(pepperID))
+	if pepper == "" {
+		currentID := os.Getenv("PASSWORD_PEPPER_ID")
+		if currentID == "" {
+			currentID = "1"
+		}
+		if pepperID == currentID {
+			pepper = os.Getenv("PASSWORD_PEPPER")
+		}
+	}
+	if pepper == "" {
+		return "", ErrUnknownPepper
+	}
+
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+type bcryptParams struct {
+	Cost int
+}
+
+func bcryptHasher() *bcryptParams {
+	return &bcryptParams{Cost: envIntRange("BCRYPT_COST", bcrypt.DefaultCost, bcrypt.MinCost, bcrypt.MaxCost)}
+}
+
+func (p *bcryptParams) Name() string { return PasswordHashBcrypt }
+
+func (p *bcryptParams) Handles(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (p *bcryptParams) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), p.Cost)
+	return string(bytes), err
+}
+
+func (p *bcryptParams) Verify(password, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (p *bcryptParams) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < p.Cost
+}
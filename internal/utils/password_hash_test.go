@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheapArgon2 keeps argon2id fast enough for tests
+func cheapArgon2(t *testing.T) {
+	t.Setenv("PASSWORD_HASH_ALGORITHM", PasswordHashArgon2id)
+	t.Setenv("ARGON2_MEMORY", "1024")
+	t.Setenv("ARGON2_ITERATIONS", "1")
+	t.Setenv("ARGON2_PARALLELISM", "1")
+	t.Setenv("PASSWORD_PEPPER", "")
+	t.Setenv("PASSWORD_PEPPER_ID", "")
+}
+
+func TestArgon2idPHCRoundTrip(t *testing.T) {
+	cheapArgon2(t)
+
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected PHC string %q", hash)
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if params.Memory != 1024 || params.Iterations != 1 || params.Parallelism != 1 {
+		t.Errorf("decoded parameters m=%d,t=%d,p=%d, want m=1024,t=1,p=1",
+			params.Memory, params.Iterations, params.Parallelism)
+	}
+	if len(salt) != 16 || len(key) != 32 || params.PepperID != "" {
+		t.Errorf("decoded salt %d bytes, key %d bytes, pepper %q", len(salt), len(key), params.PepperID)
+	}
+
+	if !CheckPasswordHash("correct horse battery staple", hash) {
+		t.Error("password does not match its own hash")
+	}
+	if CheckPasswordHash("correct horse battery stapler", hash) {
+		t.Error("wrong password matches")
+	}
+	if PasswordNeedsRehash(hash) {
+		t.Error("fresh hash needs a rehash")
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	cheapArgon2(t)
+
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	parts := strings.Split(hash, "$")
+
+	tests := map[string]string{
+		"wrong version":  strings.Replace(hash, "v=19", "v=16", 1),
+		"zero lanes":     strings.Replace(hash, "p=1", "p=0", 1),
+		"missing key":    strings.Join(parts[:5], "$"),
+		"bad salt":       strings.Join([]string{"", parts[1], parts[2], parts[3], "!!", parts[5]}, "$"),
+		"other argon2":   strings.Replace(hash, "$argon2id$", "$argon2i$", 1),
+		"truncated":      "$argon2id$v=19",
+		"not a PHC hash": "plaintext",
+	}
+	for name, malformed := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, _, err := decodeArgon2id(malformed); err != ErrUnknownPasswordHash {
+				t.Errorf("decode error %v, want ErrUnknownPasswordHash", err)
+			}
+			if CheckPasswordHash("secret", malformed) {
+				t.Error("malformed hash matches")
+			}
+		})
+	}
+}
+
+func TestArgon2idPepper(t *testing.T) {
+	cheapArgon2(t)
+	t.Setenv("PASSWORD_PEPPER", "first pepper")
+	t.Setenv("PASSWORD_PEPPER_ID", "a")
+
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil || params.PepperID != "a" {
+		t.Fatalf("decoded pepper %q, err %v, want \"a\"", params.PepperID, err)
+	}
+	if !CheckPasswordHash("secret", hash) {
+		t.Fatal("peppered password does not match")
+	}
+
+	// Rotating the pepper keeps old hashes usable through PASSWORD_PEPPER_<ID>
+	// and marks them for a rehash
+	t.Setenv("PASSWORD_PEPPER", "second pepper")
+	t.Setenv("PASSWORD_PEPPER_ID", "b")
+	t.Setenv("PASSWORD_PEPPER_A", "first pepper")
+	if !CheckPasswordHash("secret", hash) {
+		t.Error("hash with a retired pepper does not match")
+	}
+	if !PasswordNeedsRehash(hash) {
+		t.Error("hash with a retired pepper does not need a rehash")
+	}
+
+	// Without the retired pepper the hash cannot be checked at all
+	t.Setenv("PASSWORD_PEPPER_A", "")
+	if _, err := argon2idHasher().Verify("secret", hash); err != ErrUnknownPepper {
+		t.Errorf("verify error %v, want ErrUnknownPepper", err)
+	}
+	if CheckPasswordHash("secret", hash) {
+		t.Error("hash with an unknown pepper matches")
+	}
+}
+
+func TestBcryptCompatibility(t *testing.T) {
+	cheapArgon2(t)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	hash := string(legacy)
+
+	if !CheckPasswordHash("secret", hash) {
+		t.Error("bcrypt hash does not match")
+	}
+	if CheckPasswordHash("Secret", hash) {
+		t.Error("wrong password matches bcrypt hash")
+	}
+	if !PasswordNeedsRehash(hash) {
+		t.Error("bcrypt hash does not need a rehash to argon2id")
+	}
+
+	t.Setenv("PASSWORD_HASH_ALGORITHM", PasswordHashBcrypt)
+	t.Setenv("BCRYPT_COST", "4")
+	if PasswordNeedsRehash(hash) {
+		t.Error("bcrypt hash at the configured cost needs a rehash")
+	}
+	t.Setenv("BCRYPT_COST", "5")
+	if !PasswordNeedsRehash(hash) {
+		t.Error("bcrypt hash below the configured cost does not need a rehash")
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	cheapArgon2(t)
+
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+
+	tests := []struct {
+		key, value string
+		want       bool
+	}{
+		{"ARGON2_MEMORY", "2048", true},
+		{"ARGON2_ITERATIONS", "2", true},
+		{"ARGON2_PARALLELISM", "2", true},
+		{"PASSWORD_PEPPER", "pepper", true},
+		{"PASSWORD_HASH_ALGORITHM", PasswordHashBcrypt, true},
+		{"ARGON2_MEMORY", "512", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			cheapArgon2(t)
+			t.Setenv(tt.key, tt.value)
+			if got := PasswordNeedsRehash(hash); got != tt.want {
+				t.Errorf("PasswordNeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgon2idParameterRange(t *testing.T) {
+	cheapArgon2(t)
+	t.Setenv("ARGON2_PARALLELISM", "256")
+	t.Setenv("ARGON2_ITERATIONS", "1000")
+	t.Setenv("ARGON2_MEMORY", "99999999999")
+
+	params := argon2idHasher()
+	if params.Parallelism != 2 || params.Iterations != 3 || params.Memory != 64*1024 {
+		t.Errorf("out of range parameters give m=%d,t=%d,p=%d, want the defaults",
+			params.Memory, params.Iterations, params.Parallelism)
+	}
+
+	t.Setenv("BCRYPT_COST", "32")
+	if cost := bcryptHasher().Cost; cost != bcrypt.DefaultCost {
+		t.Errorf("out of range bcrypt cost gives %d, want %d", cost, bcrypt.DefaultCost)
+	}
+}
@@ -0,0 +1,500 @@
+package utils
+
+import (
+	_ "embed"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// PasswordStrength is an estimate of how hard a password is to guess, in the
+// style of zxcvbn: the password is split into the cheapest sequence of
+// patterns an attacker would try (dictionary words, sequences, repeats,
+// keyboard rows, dates) and the guesses needed for each are multiplied.
+type PasswordStrength struct {
+	// Score runs from 0 (too guessable) to 4 (very unguessable)
+	Score        int      `json:"score"`
+	GuessesLog10 float64  `json:"guesses_log10"`
+	Warning      string   `json:"warning,omitempty"`
+	Suggestions  []string `json:"suggestions,omitempty"`
+}
+
+// The bundled frequency lists are those of zxcvbn (MIT licensed): English
+// words from film and TV subtitles, first names and surnames from the US
+// census, and leaked passwords. Each word is only in the list it ranks
+// highest in.
+var (
+	//go:embed strength_words.txt
+	strengthWordList string
+	//go:embed strength_names.txt
+	strengthNameList string
+	//go:embed strength_surnames.txt
+	strengthSurnameList string
+)
+
+// rankedDictionaries maps words to their rank, most common first
+var rankedDictionaries = map[string]map[string]int{
+	"passwords": rankWords(commonPasswordList),
+	"words":     rankWords(strengthWordList),
+	"names":     rankWords(strengthNameList),
+	"surnames":  rankWords(strengthSurnameList),
+}
+
+func rankWords(list string) map[string]int {
+	ranked := map[string]int{}
+	for i, word := range strings.Fields(list) {
+		if _, ok := ranked[word]; !ok {
+			ranked[word] = i + 1
+		}
+	}
+	return ranked
+}
+
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./", "1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik9ol0p"}
+
+var l33tTable = map[rune]rune{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'}
+
+var (
+	datePattern          = regexp.MustCompile(`^(\d{1,2})[-/. ]?(\d{1,2})[-/. ]?(\d{2}|\d{4})$`)
+	yearFirstDatePattern = regexp.MustCompile(`^(\d{4})[-/. ]?(\d{1,2})[-/. ]?(\d{1,2})$`)
+)
+
+// maxStrengthLength is how many characters of a password are analysed
+const maxStrengthLength = 100
+
+type strengthMatch struct {
+	i, j       int // rune offsets, inclusive
+	pattern    string
+	dictionary string
+	token      string
+	rank       int
+	l33t       bool
+	reversed   bool
+	descending bool
+	guesses    float64
+}
+
+// EstimatePasswordStrength scores a password. userInputs, such as the email
+// address and names of the account, are treated as the most likely words.
+func EstimatePasswordStrength(password string, userInputs ...string) PasswordStrength {
+	runes := []rune(password)
+	// Anything this long is scored on its start; it keeps the matching cheap
+	if len(runes) > maxStrengthLength {
+		runes = runes[:maxStrengthLength]
+	}
+	if len(runes) == 0 {
+		return PasswordStrength{
+			Warning:     "Enter a password",
+			Suggestions: defaultStrengthSuggestions(),
+		}
+	}
+
+	matches := strengthMatches(runes, userInputs)
+	for k := range matches {
+		matches[k].guesses = matchGuesses(&matches[k])
+	}
+
+	// best[k] is the log10 of the fewest guesses for the first k runes
+	bruteForce := math.Log10(float64(bruteForceCardinality(runes)))
+	best := make([]float64, len(runes)+1)
+	via := make([]*strengthMatch, len(runes)+1)
+	for k := 1; k <= len(runes); k++ {
+		best[k] = best[k-1] + bruteForce
+		for m := range matches {
+			match := &matches[m]
+			if match.j != k-1 {
+				continue
+			}
+			minimum := 50.0
+			if match.i == match.j {
+				minimum = 10
+			}
+			if guesses := best[match.i] + math.Log10(math.Max(match.guesses, minimum)); guesses < best[k] {
+				best[k] = guesses
+				via[k] = match
+			}
+		}
+	}
+
+	var sequence []*strengthMatch
+	for k := len(runes); k > 0; {
+		if via[k] == nil {
+			k--
+			continue
+		}
+		sequence = append(sequence, via[k])
+		k = via[k].i
+	}
+
+	strength := PasswordStrength{GuessesLog10: math.Round(best[len(runes)]*100) / 100}
+	switch guesses := best[len(runes)]; {
+	case guesses < 3:
+		strength.Score = 0
+	case guesses < 6:
+		strength.Score = 1
+	case guesses < 8:
+		strength.Score = 2
+	case guesses < 10:
+		strength.Score = 3
+	default:
+		strength.Score = 4
+	}
+	if strength.Score <= 2 {
+		strength.Warning, strength.Suggestions = strengthFeedback(sequence, len(runes))
+	}
+	return strength
+}
+
+// strengthMatches finds every pattern in the password
+func strengthMatches(runes []rune, userInputs []string) []strengthMatch {
+	lower := []rune(strings.ToLower(string(runes)))
+
+	dictionaries := map[string]map[string]int{}
+	for name, words := range rankedDictionaries {
+		dictionaries[name] = words
+	}
+	inputs := map[string]int{}
+	for _, input := range userInputs {
+		for _, word := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if _, ok := inputs[word]; !ok && len([]rune(word)) >= 3 {
+				inputs[word] = len(inputs) + 1
+			}
+		}
+	}
+	dictionaries["user_inputs"] = inputs
+
+	var matches []strengthMatch
+	matches = append(matches, dictionaryMatches(runes, lower, dictionaries)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, spatialMatches(lower)...)
+	matches = append(matches, dateMatches(runes)...)
+	return matches
+}
+
+func dictionaryMatches(runes, lower []rune, dictionaries map[string]map[string]int) []strengthMatch {
+	n := len(lower)
+
+	unl33t := make([]rune, n)
+	for k, r := range lower {
+		if sub, ok := l33tTable[r]; ok {
+			unl33t[k] = sub
+		} else {
+			unl33t[k] = r
+		}
+	}
+	reversed := make([]rune, n)
+	for k, r := range lower {
+		reversed[n-1-k] = r
+	}
+
+	var matches []strengthMatch
+	for i := 0; i < n; i++ {
+		for j := i + 2; j < n; j++ {
+			for name, words := range dictionaries {
+				word := string(lower[i : j+1])
+				if rank, ok := words[word]; ok {
+					matches = append(matches, strengthMatch{i: i, j: j, pattern: "dictionary", dictionary: name,
+						token: string(runes[i : j+1]), rank: rank})
+				}
+				if l33t := string(unl33t[i : j+1]); l33t != word {
+					if rank, ok := words[l33t]; ok {
+						matches = append(matches, strengthMatch{i: i, j: j, pattern: "dictionary", dictionary: name,
+							token: string(runes[i : j+1]), rank: rank, l33t: true})
+					}
+				}
+				// reversed[n-1-j : n-i] spells lower[i : j+1] backwards
+				if rank, ok := words[string(reversed[n-1-j:n-i])]; ok && string(reversed[n-1-j:n-i]) != word {
+					matches = append(matches, strengthMatch{i: i, j: j, pattern: "dictionary", dictionary: name,
+						token: string(runes[i : j+1]), rank: rank, reversed: true})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// sequenceMatches finds runs like abc, 6543 or xyz of three or more
+func sequenceMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := 0; i < len(runes)-2; {
+		delta := runes[i+1] - runes[i]
+		if delta != 1 && delta != -1 {
+			i++
+			continue
+		}
+		j := i + 1
+		for j+1 < len(runes) && runes[j+1]-runes[j] == delta {
+			j++
+		}
+		if j-i >= 2 {
+			matches = append(matches, strengthMatch{i: i, j: j, pattern: "sequence", token: string(runes[i : j+1]), descending: delta < 0})
+		}
+		i = j
+	}
+	return matches
+}
+
+// repeatMatches finds a character or group of characters repeated back to
+// back, like aaa or abcabc
+func repeatMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := 0; i < len(runes); i++ {
+		for size := 1; i+2*size <= len(runes); size++ {
+			unit := string(runes[i : i+size])
+			j := i + size
+			for j+size <= len(runes) && string(runes[j:j+size]) == unit {
+				j += size
+			}
+			if count := (j - i) / size; count >= 2 && j-i >= 3 {
+				matches = append(matches, strengthMatch{i: i, j: j - 1, pattern: "repeat", token: unit, rank: count})
+			}
+		}
+	}
+	return matches
+}
+
+// spatialMatches finds three or more neighbouring keys of one keyboard row
+func spatialMatches(lower []rune) []strengthMatch {
+	var matches []strengthMatch
+	for _, row := range keyboardRows {
+		for i := 0; i < len(lower)-2; i++ {
+			for _, direction := range []int{1, -1} {
+				j := i
+				for j+1 < len(lower) {
+					a, b := strings.IndexRune(row, lower[j]), strings.IndexRune(row, lower[j+1])
+					if a < 0 || b < 0 || b-a != direction {
+						break
+					}
+					j++
+				}
+				if j-i >= 2 {
+					matches = append(matches, strengthMatch{i: i, j: j, pattern: "spatial", token: string(lower[i : j+1])})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// dateMatches finds years from 1900 to 2099 and day-month-year dates
+func dateMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := 0; i < len(runes); i++ {
+		for j := i + 3; j < len(runes) && j-i < 10; j++ {
+			token := string(runes[i : j+1])
+			if j-i == 3 {
+				if year, err := strconv.Atoi(token); err == nil && year >= 1900 && year <= 2099 {
+					matches = append(matches, strengthMatch{i: i, j: j, pattern: "year", token: token, rank: year})
+				}
+			}
+			if j-i < 5 {
+				continue
+			}
+			parts := datePattern.FindStringSubmatch(token)
+			if year := yearFirstDatePattern.FindStringSubmatch(token); parts == nil && year != nil {
+				parts = []string{token, year[2], year[3], year[1]}
+			}
+			if parts == nil {
+				continue
+			}
+			a, _ := strconv.Atoi(parts[1])
+			b, _ := strconv.Atoi(parts[2])
+			year, _ := strconv.Atoi(parts[3])
+			if len(parts[3]) == 2 {
+				year += 1900
+				if year < 1950 {
+					year += 100
+				}
+			}
+			validDay := func(day, month int) bool { return day >= 1 && day <= 31 && month >= 1 && month <= 12 }
+			if (validDay(a, b) || validDay(b, a)) && year >= 1900 && year <= 2099 {
+				matches = append(matches, strengthMatch{i: i, j: j, pattern: "date", token: token, rank: year})
+			}
+		}
+	}
+	return matches
+}
+
+// matchGuesses estimates how many guesses finding a match takes
+func matchGuesses(match *strengthMatch) float64 {
+	length := float64(len([]rune(match.token)))
+	switch match.pattern {
+	case "dictionary":
+		guesses := float64(match.rank) * uppercaseVariations(match.token)
+		if match.l33t {
+			guesses *= 2
+		}
+		if match.reversed {
+			guesses *= 2
+		}
+		return guesses
+	case "sequence":
+		base := 26.0
+		switch first := []rune(match.token)[0]; {
+		case strings.ContainsRune("aAzZ019", first):
+			base = 4
+		case unicode.IsDigit(first):
+			base = 10
+		}
+		if match.descending {
+			base *= 2
+		}
+		return base * length
+	case "repeat":
+		unit := []rune(match.token)
+		return math.Pow(float64(bruteForceCardinality(unit)), float64(len(unit))) * float64(match.rank)
+	case "spatial":
+		return 40 * length * length
+	case "year":
+		return yearSpace(match.rank)
+	case "date":
+		return 365 * yearSpace(match.rank)
+	}
+	return math.Pow(10, length)
+}
+
+// uppercaseVariations is how many more guesses capitalization costs; only
+// unusual capitalization helps
+func uppercaseVariations(token string) float64 {
+	var upper, lower int
+	for _, r := range token {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 1
+	case lower == 0 || upper == 1 && unicode.IsUpper([]rune(token)[0]):
+		return 2
+	}
+	return math.Pow(2, float64(upper))
+}
+
+func yearSpace(year int) float64 {
+	return math.Max(math.Abs(float64(year-time.Now().Year())), 20)
+}
+
+// bruteForceCardinality is the size of the character set an attacker would
+// have to try for the password's character classes
+func bruteForceCardinality(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < 128:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	cardinality := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			cardinality += class.size
+		}
+	}
+	return cardinality
+}
+
+func defaultStrengthSuggestions() []string {
+	return []string{
+		"Use a few words, avoid common phrases",
+		"No need for symbols, digits, or uppercase letters",
+	}
+}
+
+// strengthFeedback explains the weakest part of a guessable password, based
+// on its longest pattern
+func strengthFeedback(sequence []*strengthMatch, length int) (string, []string) {
+	if len(sequence) == 0 {
+		return "", defaultStrengthSuggestions()
+	}
+
+	longest := sequence[0]
+	for _, match := range sequence[1:] {
+		if match.j-match.i > longest.j-longest.i {
+			longest = match
+		}
+	}
+
+	suggestions := []string{"Add another word or two. Uncommon words are better."}
+	var warning string
+	switch longest.pattern {
+	case "dictionary":
+		switch {
+		case longest.dictionary == "user_inputs":
+			warning = "Passwords based on your email address or name are easy to guess"
+		case longest.dictionary == "passwords" && longest.j-longest.i+1 == length && !longest.l33t && !longest.reversed:
+			switch {
+			case longest.rank <= 10:
+				warning = "This is a top-10 common password"
+			case longest.rank <= 100:
+				warning = "This is a top-100 common password"
+			default:
+				warning = "This is a very common password"
+			}
+		case longest.dictionary == "passwords":
+			warning = "This is similar to a commonly used password"
+		case longest.dictionary == "names" || longest.dictionary == "surnames":
+			if longest.j-longest.i+1 == length {
+				warning = "Names and surnames by themselves are easy to guess"
+			} else {
+				warning = "Common names and surnames are easy to guess"
+			}
+		case longest.j-longest.i+1 == length:
+			warning = "A word by itself is easy to guess"
+		default:
+			warning = "Common names and words are easy to guess"
+		}
+
+		token := []rune(longest.token)
+		if unicode.IsUpper(token[0]) && uppercaseVariations(longest.token) == 2 && strings.ToUpper(longest.token) != longest.token {
+			suggestions = append(suggestions, "Capitalization doesn't help very much")
+		} else if strings.ToUpper(longest.token) == longest.token && strings.ToLower(longest.token) != longest.token {
+			suggestions = append(suggestions, "All-uppercase is almost as easy to guess as all-lowercase")
+		}
+		if longest.reversed {
+			suggestions = append(suggestions, "Reversed words aren't much harder to guess")
+		}
+		if longest.l33t {
+			suggestions = append(suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much")
+		}
+	case "spatial":
+		warning = "Straight rows of keys are easy to guess"
+		suggestions = append(suggestions, "Use a longer keyboard pattern with more turns")
+	case "repeat":
+		if len([]rune(longest.token)) == 1 {
+			warning = `Repeats like "aaa" are easy to guess`
+		} else {
+			warning = `Repeats like "abcabcabc" are only slightly harder to guess than "abc"`
+		}
+		suggestions = append(suggestions, "Avoid repeated words and characters")
+	case "sequence":
+		warning = "Sequences like abc or 6543 are easy to guess"
+		suggestions = append(suggestions, "Avoid sequences")
+	case "year":
+		warning = "Recent years are easy to guess"
+		suggestions = append(suggestions, "Avoid recent years", "Avoid years that are associated with you")
+	case "date":
+		warning = "Dates are often easy to guess"
+		suggestions = append(suggestions, "Avoid dates and years that are associated with you")
+	}
+	return warning, suggestions
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		score    int
+		warning  string
+	}{
+		{"", 0, "Enter a password"},
+		{"password", 0, "This is a top-10 common password"},
+		{"123456", 0, "This is a top-10 common password"},
+		{"iloveyou", 0, "This is a top-100 common password"},
+		{"Password1", 0, "This is a very common password"},
+		{"p@ssw0rd", 0, "This is similar to a commonly used password"},
+		{"drowssap", 0, "This is similar to a commonly used password"},
+		{"monkey123", 1, "This is similar to a commonly used password"},
+		{"jennifer", 0, "Names and surnames by themselves are easy to guess"},
+		{"Johnson", 0, "Names and surnames by themselves are easy to guess"},
+		{"jessica1987", 1, "Common names and surnames are easy to guess"},
+		{"correcthorse", 1, "Common names and words are easy to guess"},
+		{"abcdefgh", 0, "Sequences like abc or 6543 are easy to guess"},
+		{"aaaaaaaa", 0, `Repeats like "aaa" are easy to guess`},
+		{"ghghgh", 1, `Repeats like "abcabcabc" are only slightly harder to guess than "abc"`},
+		{"12/25/1990", 1, "Dates are often easy to guess"},
+		{"correct horse battery staple", 4, ""},
+		{"Jm7$kq2!Lp9#", 4, ""},
+		{"Kx8!quiet-harbor-Lamp", 4, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got := EstimatePasswordStrength(tt.password)
+			if got.Score != tt.score || got.Warning != tt.warning {
+				t.Errorf("score %d, warning %q; want %d, %q", got.Score, got.Warning, tt.score, tt.warning)
+			}
+			if got.Score <= 2 && len(got.Suggestions) == 0 {
+				t.Error("weak password has no suggestions")
+			}
+			if got.Score > 2 && (got.Warning != "" || len(got.Suggestions) != 0) {
+				t.Error("strong password has feedback")
+			}
+		})
+	}
+}
+
+func TestEstimatePasswordStrengthUserInputs(t *testing.T) {
+	const password = "zenobiaquillfeather"
+
+	without := EstimatePasswordStrength(password)
+	with := EstimatePasswordStrength(password, "zenobia.quillfeather@example.com")
+	if with.GuessesLog10 >= without.GuessesLog10 {
+		t.Errorf("user inputs do not make the password weaker: %.2f with, %.2f without",
+			with.GuessesLog10, without.GuessesLog10)
+	}
+	if with.Score > 1 || with.Warning != "Passwords based on your email address or name are easy to guess" {
+		t.Errorf("score %d, warning %q", with.Score, with.Warning)
+	}
+}
+
+func TestEstimatePasswordStrengthIsMonotonic(t *testing.T) {
+	// Adding random characters never makes a password easier to guess
+	password := "sunshine"
+	previous := EstimatePasswordStrength(password).GuessesLog10
+	for _, extra := range []string{"7", "#", "Q", "v", "2", "!", "m"} {
+		password += extra
+		guesses := EstimatePasswordStrength(password).GuessesLog10
+		if guesses < previous {
+			t.Errorf("%q: %.2f guesses, fewer than the %.2f of its prefix", password, guesses, previous)
+		}
+		previous = guesses
+	}
+}
+
+func TestEstimatePasswordStrengthLongInput(t *testing.T) {
+	got := EstimatePasswordStrength(strings.Repeat("Xq7!", 1000))
+	if got.GuessesLog10 <= 0 {
+		t.Errorf("long password scored %.2f", got.GuessesLog10)
+	}
+}
+
+func TestBundledDictionaries(t *testing.T) {
+	for name, minimum := range map[string]int{
+		"passwords": 5000,
+		"words":     30000,
+		"names":     4000,
+		"surnames":  40000,
+	} {
+		if got := len(rankedDictionaries[name]); got < minimum {
+			t.Errorf("%s dictionary has %d words, want at least %d", name, got, minimum)
+		}
+	}
+	if rankedDictionaries["passwords"]["password"] > 10 {
+		t.Error("dictionaries are not ranked by frequency")
+	}
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"time"
+
+	// Embed the time zone database so timezone validation does not depend
+	// on the zoneinfo files of the host
+	_ "time/tzdata"
+)
+
+var (
+	uuidPattern   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+	slugPattern           = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	roleNamePattern       = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+	permissionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*(:[a-z0-9_]+)+$`)
+)
+
+// IsUUID reports whether s is a canonical UUID string
+func IsUUID(s string) bool {
+	return uuidPattern.MatchString(s)
+}
+
+// IsLocale reports whether s looks like a BCP 47 language tag such as "en" or "pt-BR"
+func IsLocale(s string) bool {
+	return localePattern.MatchString(s)
+}
+
+// IsTimezone reports whether s is an IANA time zone name such as "Europe/Berlin"
+func IsTimezone(s string) bool {
+	if s == "" || s == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(s)
+	return err == nil
+}
+
+// IsHTTPURL reports whether s is an absolute http or https URL
+func IsHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// IsSlug reports whether s is a URL slug such as "acme-corp", at most 100
+// characters long
+func IsSlug(s string) bool {
+	return len(s) <= 100 && slugPattern.MatchString(s)
+}
+
+// IsRoleName reports whether s is a valid role name such as "support"
+func IsRoleName(s string) bool {
+	return roleNamePattern.MatchString(s)
+}
+
+// IsPermissionName reports whether s is a valid permission name such as
+// "users:write"
+func IsPermissionName(s string) bool {
+	return len(s) <= 100 && permissionNamePattern.MatchString(s)
+}